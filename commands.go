@@ -0,0 +1,328 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/dwood15/mediaplayer/sockets"
+	"github.com/dwood15/mediaplayer/songplayer"
+)
+
+//runCommand runs the subcommand named by args, if there is one. Returns false when the
+//player should launch as usual.
+func runCommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+
+	switch args[0] {
+	case "replay":
+		runReplay(args[1:])
+	case "simulate":
+		runSimulate(args[1:])
+	case "playlist":
+		runPlaylist(args[1:])
+	case "queue":
+		runQueue(args[1:])
+	case "explain":
+		runExplain(args[1:])
+	case "seek":
+		runSeek(args[1:])
+	case "volume":
+		runVolume(args[1:])
+	case "previous", "restart":
+		runTransport(args[0])
+	default:
+		fmt.Printf("unknown command: %s\n", args[0])
+		os.Exit(2)
+	}
+
+	return true
+}
+
+//runReplay re-runs the compute captured in a replay snapshot and prints the resulting batch.
+//usage: mediaplayer replay [snapshot file]
+func runReplay(args []string) {
+	var snapshot string
+	if len(args) > 0 {
+		snapshot = args[0]
+	}
+
+	lib, err := songplayer.Replay(snapshot)
+	if err != nil {
+		fmt.Println("unable to replay compute: " + err.Error())
+		os.Exit(1)
+	}
+
+	fmt.Printf("replayed compute with seed [%d]\n", lib.Seed)
+
+	for i, song := range lib.Batch() {
+		fmt.Printf("%4d %10.2f  %s\n", i+1, song.Score, path.Base(song.FileName))
+	}
+}
+
+//runSimulate plays through simulated listening sessions and reports how fairly songs came up.
+//usage: mediaplayer simulate [flags]
+func runSimulate(args []string) {
+	var cfg songplayer.SimConfig
+
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	fs.StringVar(&cfg.Library, "library", "", "library cache to simulate against, instead of a synthetic library")
+	fs.IntVar(&cfg.Songs, "songs", 500, "number of songs in the synthetic library")
+	fs.IntVar(&cfg.Sessions, "sessions", 100, "number of listening sessions")
+	fs.IntVar(&cfg.SessionSongs, "session-songs", 20, "songs heard per session")
+	fs.DurationVar(&cfg.SessionGap, "session-gap", 24*time.Hour, "time between sessions")
+	fs.Float64Var(&cfg.SkipRate, "skip-rate", 0.2, "average chance of skipping a song")
+	fs.Int64Var(&cfg.Seed, "seed", 1, "seed for the shuffle and the skip model")
+	_ = fs.Parse(args)
+
+	report, err := songplayer.Simulate(cfg)
+	if err != nil {
+		fmt.Println("unable to simulate: " + err.Error())
+		os.Exit(1)
+	}
+
+	fmt.Printf("library size:           %d\n", report.LibrarySize)
+	fmt.Printf("songs heard:            %d (%d skipped)\n", report.Heard, report.Skipped)
+	fmt.Printf("repeats:                %d\n", report.Repeats)
+	fmt.Printf("repeat interval:        min %d, median %.1f, mean %.1f songs\n",
+		report.MinRepeatInterval, report.MedianRepeatInterval, report.MeanRepeatInterval)
+	fmt.Printf("gini of play counts:    %.3f\n", report.Gini)
+
+	fmt.Println("coverage by session:")
+	step := len(report.Coverage) / 10
+	if step == 0 {
+		step = 1
+	}
+
+	for i := step - 1; i < len(report.Coverage); i += step {
+		fmt.Printf("  %4d: %5.1f%%\n", i+1, 100*report.Coverage[i])
+	}
+}
+
+//runPlaylist lists the songs currently matching a smart playlist, or a rule typed out in full.
+//usage: mediaplayer playlist <name | rule>
+func runPlaylist(args []string) {
+	if len(args) == 0 {
+		fmt.Println("usage: mediaplayer playlist <name | rule>")
+		os.Exit(2)
+	}
+
+	q, err := songplayer.SmartPlaylist(args[0])
+	if err != nil {
+		if q, err = songplayer.ParseQuery(strings.Join(args, " ")); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+	}
+
+	lib, err := songplayer.ReadLibrary("")
+	if err != nil {
+		fmt.Println("unable to read the library cache: " + err.Error())
+		os.Exit(1)
+	}
+
+	matches := lib.Matches(q)
+	for _, song := range matches {
+		fmt.Println(song.FileName)
+	}
+
+	fmt.Printf("%d of %d songs match: %s\n", len(matches), len(lib.Songs), q)
+}
+
+//queueSignals maps the queue subcommands onto the commands the player understands.
+var queueSignals = map[string]int64{
+	"add":    songplayer.SignalEnqueue,
+	"next":   songplayer.SignalPlayNext,
+	"remove": songplayer.SignalDequeue,
+	"move":   songplayer.SignalMoveQueued,
+}
+
+//runQueue edits the play next queue of a running player.
+//usage: mediaplayer queue <add | next | remove | move> <song> [position]
+func runQueue(args []string) {
+	if len(args) < 2 || queueSignals[args[0]] == 0 {
+		fmt.Println("usage: mediaplayer queue <add | next | remove | move> <song> [position]")
+		os.Exit(2)
+	}
+
+	cmd := songplayer.Command{Signal: queueSignals[args[0]], Target: args[1]}
+
+	if cmd.Signal == songplayer.SignalMoveQueued {
+		if len(args) < 3 {
+			fmt.Println("move needs the position to move the song to, starting from 0")
+			os.Exit(2)
+		}
+
+		pos, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			fmt.Println("invalid position: " + err.Error())
+			os.Exit(2)
+		}
+		cmd.Value = pos
+	}
+
+	if err := sockets.SendCommand(&unix.SockaddrUnix{Name: sockName}, cmd); err != nil {
+		fmt.Println("unable to reach the player: " + err.Error())
+		os.Exit(1)
+	}
+}
+
+//runExplain prints why a song scored the way it did on the latest compute, and where that put it
+//in the batch. The running player is asked first, falling back to the library cache.
+//usage: mediaplayer explain [song]
+func runExplain(args []string) {
+	var song string
+	if len(args) > 0 {
+		song = strings.Join(args, " ")
+	}
+
+	e, err := explain(song)
+	if err != nil {
+		fmt.Println("unable to explain: " + err.Error())
+		os.Exit(1)
+	}
+
+	b := e.ScoreBreakdown
+	fmt.Println(e.Song)
+	if b.Computed == 0 {
+		fmt.Println("not scored yet")
+		return
+	}
+
+	fmt.Printf("computed at %s\n", time.Unix(b.Computed, 0).Format(time.RFC1123))
+	fmt.Printf("  previous score   %10.2f\n", b.Previous)
+	fmt.Printf("  new song jitter  %+10.2f\n", b.NewSongJitter)
+	fmt.Printf("  jitter           %+10.2f\n", b.Jitter)
+	fmt.Printf("  skip penalty     %+10.2f\n", -b.SkipPenalty)
+	fmt.Printf("  skip recovery    %+10.2f\n", b.SkipRecovery)
+	fmt.Printf("  staleness bonus  %+10.2f\n", b.Staleness)
+	fmt.Printf("  avg adjustment   %+10.2f\n", b.AvgAdjustment)
+	fmt.Printf("  score            %10.2f\n", b.Score)
+	fmt.Printf("  preference       %+10.2f\n", b.Preference)
+	fmt.Printf("  time of day      %+10.2f\n", b.TimeContext)
+	fmt.Printf("  multiplier       %10.2fx\n", b.Multiplier)
+	fmt.Printf("  rank             %10.2f\n", b.Rank)
+	fmt.Printf("  pick             %10.2f\n", b.Pick)
+
+	switch {
+	case b.Excluded != "":
+		fmt.Printf("excluded from the batch: %s\n", b.Excluded)
+	case e.BatchPosition == 0:
+		fmt.Printf("outside the batch of %d songs\n", e.BatchSize)
+	case e.Played:
+		fmt.Printf("already played, at %d of %d in the batch\n", e.BatchPosition, e.BatchSize)
+	default:
+		fmt.Printf("at %d of %d in the batch\n", e.BatchPosition, e.BatchSize)
+	}
+}
+
+//explain asks the running player to explain a song, or reads the explanation from the cache when
+//the player isn't running.
+func explain(song string) (songplayer.Explanation, error) {
+	var e songplayer.Explanation
+
+	addr := &unix.SockaddrUnix{Name: sockName}
+	reply, err := sockets.Request(addr, songplayer.Command{Signal: songplayer.SignalExplain, Target: song}, "explain")
+	if err == nil {
+		err = json.Unmarshal(reply, &e)
+		return e, err
+	}
+
+	//an error other than failing to connect came from the player itself
+	if errno, ok := err.(unix.Errno); !ok || (errno != unix.ENOENT && errno != unix.ECONNREFUSED) {
+		return e, err
+	}
+
+	lib, err := songplayer.ReadLibrary("")
+	if err != nil {
+		return e, err
+	}
+
+	return lib.Explain(song)
+}
+
+//runSeek moves playback of the running player. Positions starting with + or - are relative to
+//where playback is, anything else is measured from the start of the song.
+//usage: mediaplayer seek <+30s | -5s | 1m20s>
+func runSeek(args []string) {
+	if len(args) != 1 {
+		fmt.Println("usage: mediaplayer seek <+30s | -5s | 1m20s>")
+		os.Exit(2)
+	}
+
+	d, err := time.ParseDuration(args[0])
+	if err != nil {
+		fmt.Println("invalid position: " + err.Error())
+		os.Exit(2)
+	}
+
+	cmd := songplayer.Command{Signal: songplayer.SignalSeekTo, Value: int64(d / time.Millisecond)}
+	if strings.HasPrefix(args[0], "+") || strings.HasPrefix(args[0], "-") {
+		cmd.Signal = songplayer.SignalSeek
+	}
+
+	if err := sockets.SendCommand(&unix.SockaddrUnix{Name: sockName}, cmd); err != nil {
+		fmt.Println("unable to reach the player: " + err.Error())
+		os.Exit(1)
+	}
+}
+
+//runVolume changes the volume of the running player. Levels starting with + or - are relative to
+//the current volume, anything else sets it outright.
+//usage: mediaplayer volume <+5 | -5 | 60 | mute | unmute>
+func runVolume(args []string) {
+	if len(args) != 1 {
+		fmt.Println("usage: mediaplayer volume <+5 | -5 | 60 | mute | unmute>")
+		os.Exit(2)
+	}
+
+	var cmd songplayer.Command
+	switch args[0] {
+	case "mute":
+		cmd = songplayer.Command{Signal: songplayer.SignalMute, Value: 1}
+	case "unmute":
+		cmd = songplayer.Command{Signal: songplayer.SignalMute}
+	default:
+		v, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			fmt.Println("invalid volume: " + args[0])
+			os.Exit(2)
+		}
+
+		cmd = songplayer.Command{Signal: songplayer.SignalSetVolume, Value: v}
+		if strings.HasPrefix(args[0], "+") || strings.HasPrefix(args[0], "-") {
+			cmd.Signal = songplayer.SignalVolume
+		} else if v > 100 {
+			fmt.Println("volume must be between 0 and 100")
+			os.Exit(2)
+		}
+	}
+
+	if err := sockets.SendCommand(&unix.SockaddrUnix{Name: sockName}, cmd); err != nil {
+		fmt.Println("unable to reach the player: " + err.Error())
+		os.Exit(1)
+	}
+}
+
+//runTransport sends the running player back to the last song heard, or to the start of this one.
+//usage: mediaplayer <previous | restart>
+func runTransport(name string) {
+	cmd := songplayer.Command{Signal: songplayer.SignalPrevious}
+	if name == "restart" {
+		cmd.Signal = songplayer.SignalRestart
+	}
+
+	if err := sockets.SendCommand(&unix.SockaddrUnix{Name: sockName}, cmd); err != nil {
+		fmt.Println("unable to reach the player: " + err.Error())
+		os.Exit(1)
+	}
+}
@@ -4,15 +4,31 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"os"
+
+	"github.com/dwood15/mediaplayer/songplayer"
 )
 
 type config struct {
-	MusicDir        string `json:"music_dir"` //MusicDir is the directory where the
-	MaxPlaylistSize int    `json:"max_playlist_size"`
+	MusicDir        string                    `json:"music_dir"` //MusicDir is the directory where the
+	MaxPlaylistSize int                       `json:"max_playlist_size"`
+	ScoreWeights    songplayer.ScoreWeights   `json:"score_weights"`
+	Seed            int64                     `json:"seed,omitempty"` //Seed makes shuffles reproducible, 0 leaves them random
+	Spread          songplayer.SpreadGaps     `json:"spread"`
+	Temperature     float64                   `json:"temperature"` //Temperature of 0 plays strictly by rank, higher is more random
+	SmartPlaylists  map[string]string         `json:"smart_playlists,omitempty"`
+	Playlist        string                    `json:"playlist,omitempty"` //Playlist names the smart playlist to shuffle, all songs when empty
+	AlbumMode       bool                      `json:"album_mode"`         //AlbumMode shuffles whole albums, playing their tracks in order
+	NoRepeat        songplayer.NoRepeatWindow `json:"no_repeat"`
+	WeightRules     []songplayer.WeightRule   `json:"weight_rules,omitempty"`
+	Discovery       songplayer.Discovery      `json:"discovery"`
+	Output          songplayer.OutputConfig   `json:"output"`
+	Crossfade       float64                   `json:"crossfade_seconds"` //Crossfade overlaps the end of each song with the next, 0 turns it off
+	Normalization   songplayer.Normalization  `json:"normalization"`
 }
 
 func loadConfig() config {
-	var cfg config
+	//weights the config leaves out keep their defaults, while ones set to 0 are turned off
+	cfg := config{ScoreWeights: songplayer.DefaultScoreWeights}
 
 	f, err := os.Open("config.json")
 
@@ -28,6 +44,9 @@ func loadConfig() config {
 
 		cfg.MusicDir = h + "/Music"
 		cfg.MaxPlaylistSize = 25
+		cfg.ScoreWeights = songplayer.DefaultScoreWeights
+		cfg.Spread = songplayer.SpreadGaps{Artist: 2, Album: 4, Directory: 4}
+		cfg.NoRepeat = songplayer.NoRepeatWindow{Songs: 50}
 
 		f, err := os.Create("config.json")
 		if err != nil {
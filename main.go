@@ -1,7 +1,9 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"os"
 	"os/signal"
@@ -28,6 +30,34 @@ func init() {
 	cfg := loadConfig()
 	songplayer.SetLibraryDir(cfg.MusicDir)
 	songplayer.SetPlaylistMaxSize(cfg.MaxPlaylistSize)
+	songplayer.SetScoreWeights(cfg.ScoreWeights)
+	songplayer.SetSeed(cfg.Seed)
+	songplayer.SetSpreadGaps(cfg.Spread)
+	songplayer.SetTemperature(cfg.Temperature)
+	songplayer.SetAlbumMode(cfg.AlbumMode)
+	songplayer.SetNoRepeatWindow(cfg.NoRepeat)
+	songplayer.SetDiscovery(cfg.Discovery)
+	songplayer.SetCrossfade(time.Duration(cfg.Crossfade * float64(time.Second)))
+
+	if err := songplayer.SetSmartPlaylists(cfg.SmartPlaylists); err != nil {
+		panic(err)
+	}
+
+	if err := songplayer.UsePlaylist(cfg.Playlist); err != nil {
+		panic(err)
+	}
+
+	if err := songplayer.SetWeightRules(cfg.WeightRules); err != nil {
+		panic(err)
+	}
+
+	if err := songplayer.SetOutput(cfg.Output); err != nil {
+		panic(err)
+	}
+
+	if err := songplayer.SetNormalization(cfg.Normalization); err != nil {
+		panic(err)
+	}
 	go handleShutdown()
 }
 
@@ -62,6 +92,8 @@ func amServ() {
 		OnConnect: func(cFD int, done chan bool) {
 			fmt.Println("client connection made!")
 
+			var pending []byte
+
 			for {
 				select {
 				case ss := <-songplayer.SongState:
@@ -82,9 +114,8 @@ func amServ() {
 					// CHANGES BETWEEN TESTS
 					bytesToSend, _ := json.Marshal(ss)
 
-					if len(bytesToSend) > szOf + 128 {
-						fmt.Println("WARNING: NUMBER OF BYTES LARGER THAN CLIENT EXPECTS: ", len(bytesToSend))
-					}
+					//newline terminated, so the client can tell where one state ends and the next begins
+					bytesToSend = append(bytesToSend, '\n')
 
 					if _, err := unix.Write(cFD, bytesToSend); err != nil {
 						fmt.Printf("non-nil err when attempting sendTo: %v\n", err)
@@ -100,6 +131,8 @@ func amServ() {
 						fmt.Println("client connection closed. returning from OnConnect")
 						return
 					}
+
+					pending = readCommands(cFD, pending)
 				}
 			}
 		},
@@ -118,10 +151,89 @@ func amServ() {
 	songplayer.GetLibrary().BeginPlaying()
 }
 
-var uiInput = make(chan int64)
+//readCommands hands every complete command the client has sent off to the player, returning
+//whatever's left of a command that hasn't fully arrived yet.
+func readCommands(cFD int, pending []byte) []byte {
+	b := make([]byte, 512)
+
+	n, err := unix.Read(cFD, b)
+	if err != nil || n <= 0 {
+		return pending
+	}
+
+	pending = append(pending, b[:n]...)
+
+	for {
+		end := bytes.IndexByte(pending, '\n')
+		if end < 0 {
+			return pending
+		}
+
+		var cmd songplayer.Command
+		if err := json.Unmarshal(pending[:end], &cmd); err != nil {
+			fmt.Printf("dropping malformed command: %v\n", err)
+		} else if cmd.Signal == songplayer.SignalExplain {
+			//queries are answered on the connection that asked
+			replyExplain(cFD, cmd.Target)
+		} else if cmd.Signal == songplayer.SignalSearch {
+			replySearch(cFD, cmd.Target)
+		} else {
+			//the player may be busy sending us its state, so don't wait around for it
+			go songplayer.HandleCommand(cmd)
+		}
+
+		pending = pending[end+1:]
+	}
+}
+
+//replyExplain answers an explain query with {"explain": ...}, or {"error": ...} when there's no such song.
+func replyExplain(cFD int, target string) {
+	reply := make(map[string]interface{})
+
+	if e, err := songplayer.ExplainSong(target); err != nil {
+		reply["error"] = err.Error()
+	} else {
+		reply["explain"] = e
+	}
+
+	b, _ := json.Marshal(reply)
+	if _, err := unix.Write(cFD, append(b, '\n')); err != nil {
+		fmt.Printf("non-nil err when answering explain: %v\n", err)
+	}
+}
+
+//replySearch answers a search with {"songs": [...]}, the file names of the matching songs.
+func replySearch(cFD int, text string) {
+	b, _ := json.Marshal(map[string][]string{"songs": songplayer.SearchSongs(text)})
+	if _, err := unix.Write(cFD, append(b, '\n')); err != nil {
+		fmt.Printf("non-nil err when answering search: %v\n", err)
+	}
+}
+
+var uiInput = make(chan songplayer.Command)
 var state = new(atomic.Value)
 
+var seed = flag.Int64("seed", 0, "seed the shuffle for a reproducible session, overriding the config")
+var playlist = flag.String("playlist", "", "only shuffle songs matching this smart playlist, overriding the config")
+
 func main() {
+	flag.Parse()
+
+	if *seed != 0 {
+		songplayer.SetSeed(*seed)
+	}
+
+	if *playlist != "" {
+		if err := songplayer.UsePlaylist(*playlist); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(2)
+		}
+	}
+
+	if runCommand(flag.Args()) {
+		os.Exit(0)
+	}
+
 	state.Store(songplayer.PlayingSong{})
 
 	c := sockets.Client{
@@ -155,12 +267,12 @@ func main() {
 
 func handleShutdown() {
 	// Handle graceful shutdown
-	quit := make(chan os.Signal)
+	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT)
 	<-quit
 
 	//Indicate to the player that we're about to GO DOWN
-	uiInput <- songplayer.SignalExit
+	uiInput <- songplayer.Command{Signal: songplayer.SignalExit}
 
 	os.Exit(0)
 }
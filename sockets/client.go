@@ -5,6 +5,7 @@ package sockets
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"sort"
 	"sync/atomic"
@@ -20,6 +21,7 @@ import (
 type Client struct {
 	Addr            *unix.SockaddrUnix
 	ServerSongState *atomic.Value
+	pending         []byte
 }
 
 type fielded struct {
@@ -67,7 +69,7 @@ func (c *Client) handleRcv(fd int, rcvd []byte) {
 	n, err := unix.Read(fd, rcvd)
 	if err != nil && !err.(unix.Errno).Temporary() {
 		panic("launch client: " + err.Error())
-	} else if n == -1 {
+	} else if n <= 0 {
 		//	time.Sleep(1 * time.Millisecond)
 		return
 	}
@@ -78,27 +80,30 @@ func (c *Client) handleRcv(fd int, rcvd []byte) {
 	//st, _ := binary.Varint(rcvd[stIdx : stIdx+10 : szOf])
 	//ss, _ := binary.Uvarint(rcvd[ssIdx : ssIdx+10 : szOf])
 	//sl, _ := binary.Varint(rcvd[slIdx : slIdx+10])
-	sp := songplayer.PlayingSong{}
 
-	/* QUARANTINE SECTION */
-	//THIS SECTION OF CODE IS INCREDIBLY FINNICKY, AND I HAVEN'T
-	// SPENT TIME TO FIGURE OUT HOW TO MAKE IT ROBUST...
-	// CONSIDER WRITING SOME UNIT TESTS OR MAKING INCREDIBLY MINOR
-	// CHANGES BETWEEN TESTS
-	rcv := bytes.Trim(rcvd, "\x00")
+	//The server terminates every state it sends with a newline, so a read may end partway
+	//through one, or hold several. Only whole lines are decoded, the rest waits for the next read.
+	c.pending = append(c.pending, rcvd[:n]...)
 
-	err = json.Unmarshal(rcv, &sp)
+	for {
+		end := bytes.IndexByte(c.pending, '\n')
+		if end < 0 {
+			return
+		}
 
-	if err != nil {
-		panic("json unmarshalling err: " + err.Error())
-	}
+		sp := songplayer.PlayingSong{}
 
-	c.ServerSongState.Store(sp)
-	/*END QUARANTINE SECTION */
+		if err = json.Unmarshal(c.pending[:end], &sp); err != nil {
+			panic("json unmarshalling err: " + err.Error())
+		}
+
+		c.pending = c.pending[end+1:]
+		c.ServerSongState.Store(sp)
+	}
 }
 
-//LaunchClient takes sockname
-func (c *Client) LaunchClient(onInput chan int64) error {
+//LaunchClient takes sockname. Commands received on onInput are sent along to the server.
+func (c *Client) LaunchClient(onInput chan songplayer.Command) error {
 	if c.ServerSongState == nil {
 		panic("ServerSongState atomic value must not be nil")
 	}
@@ -116,8 +121,7 @@ func (c *Client) LaunchClient(onInput chan int64) error {
 	}
 
 	go func() {
-		var toSend int64
-		sendBuf := make([]byte, 10)
+		var sendBuf []byte
 		rcvd := make([]byte, unsafe.Sizeof(songplayer.PlayingSong{})+128)
 
 		fmt.Println("Client now handling the recv loop")
@@ -125,18 +129,15 @@ func (c *Client) LaunchClient(onInput chan int64) error {
 		for {
 			c.handleRcv(fd, rcvd)
 
-			//clear rcvd - this should help us avoid strange marshalling errors
-			for i := 0; i < len(rcvd); i++ {
-				rcvd[i] = 0
+			select {
+			case cmd := <-onInput:
+				//commands are newline terminated json, same as the state the server sends back.
+				sendBuf, _ = json.Marshal(cmd)
+				sendBuf = append(sendBuf, '\n')
+			default:
 			}
 
-			//select {
-			//case toSend = <-onInput:
-			//	fmt.Println("found data to send")
-			//	binary.PutVarint(sendBuf, toSend)
-			//}
-
-			if toSend != 0 {
+			if len(sendBuf) != 0 {
 			trySend:
 				fmt.Println("found data to send")
 				if _, err := unix.Write(fd, sendBuf); err != nil {
@@ -152,10 +153,91 @@ func (c *Client) LaunchClient(onInput chan int64) error {
 						panic(err)
 					}
 				}
-				toSend = 0
+				sendBuf = nil
 			}
 		}
 	}()
 
 	return nil
 }
+
+//SendCommand connects to the server just long enough to hand it a single command.
+func SendCommand(addr *unix.SockaddrUnix, cmd songplayer.Command) error {
+	fd, err := unix.Socket(unix.AF_LOCAL, unix.SOCK_STREAM, 0)
+	if err != nil {
+		return err
+	}
+
+	defer unix.Close(fd)
+
+	if err = unix.Connect(fd, addr); err != nil {
+		return err
+	}
+
+	b, _ := json.Marshal(cmd)
+	_, err = unix.Write(fd, append(b, '\n'))
+	return err
+}
+
+//Request sends a query to the server and waits for its reply, the first object it sends back
+//holding key. The server reports failures as {"error": "..."}.
+func Request(addr *unix.SockaddrUnix, cmd songplayer.Command, key string) (json.RawMessage, error) {
+	fd, err := unix.Socket(unix.AF_LOCAL, unix.SOCK_STREAM, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	defer unix.Close(fd)
+
+	//don't hang around forever if the server never answers
+	tv := unix.NsecToTimeval(int64(5 * time.Second))
+	if err = unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+		return nil, err
+	}
+
+	if err = unix.Connect(fd, addr); err != nil {
+		return nil, err
+	}
+
+	b, _ := json.Marshal(cmd)
+	if _, err = unix.Write(fd, append(b, '\n')); err != nil {
+		return nil, err
+	}
+
+	//the server keeps sending its state as well, so skip past anything that isn't the reply
+	var pending []byte
+	rcvd := make([]byte, 4096)
+
+	for {
+		for end := bytes.IndexByte(pending, '\n'); end >= 0; end = bytes.IndexByte(pending, '\n') {
+			var reply map[string]json.RawMessage
+			line := pending[:end]
+			pending = pending[end+1:]
+
+			if json.Unmarshal(line, &reply) != nil {
+				continue
+			}
+
+			if msg, ok := reply["error"]; ok {
+				var s string
+				_ = json.Unmarshal(msg, &s)
+				return nil, errors.New(s)
+			}
+
+			if v, ok := reply[key]; ok {
+				return v, nil
+			}
+		}
+
+		n, err := unix.Read(fd, rcvd)
+		if err != nil {
+			return nil, err
+		}
+
+		if n == 0 {
+			return nil, errors.New("server closed the connection without replying")
+		}
+
+		pending = append(pending, rcvd[:n]...)
+	}
+}
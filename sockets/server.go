@@ -4,6 +4,7 @@ package sockets
 
 import (
 	"fmt"
+	"sync"
 	"syscall"
 
 	"golang.org/x/sys/unix"
@@ -13,6 +14,7 @@ type Server struct {
 	SockAddr        *unix.SockaddrUnix
 	OnConnect       func(int, chan bool)
 	shouldClose     bool
+	mu              sync.Mutex
 	openConnections []chan bool
 }
 
@@ -76,24 +78,25 @@ func (s *Server) CloseServer() {
 func (s *Server) listenForConections(fd int) {
 	defer syscall.Close(fd)
 
-	onConnClose := make(chan bool)
-
-	var numOpen int
-
 	fmt.Println("server is listening for connections")
 	for /*waitFor := 1 * time.Millisecond*/ s.shouldClose == false /*<-time.After(waitFor)*/ {
 		nfd, _, err := unix.Accept(fd)
 
 		if err == nil {
 			conn := make(chan bool)
-			numOpen++
+
+			s.mu.Lock()
+			s.openConnections = append(s.openConnections, conn)
+			s.mu.Unlock()
+
 			go func() {
+				//the connection's done with as soon as OnConnect returns
 				defer unix.Close(nfd)
+				defer s.dropConnection(conn)
+
 				fmt.Println("launching client connection")
 				s.OnConnect(nfd, conn)
-				onConnClose <- true
 			}()
-			s.openConnections = append(s.openConnections, conn)
 			fmt.Println("connection found and added to the open slice")
 			continue
 		}
@@ -101,16 +104,19 @@ func (s *Server) listenForConections(fd int) {
 		if !(err.(unix.Errno)).Temporary() {
 			panic("non-temporary error received")
 		}
+	}
+	fmt.Println("closing server listenForConnections loop")
+}
 
-		//if numOpen > 0 && waitFor < 1*time.Second {
-		//	waitFor += 1 * time.Microsecond
-		//}
+//dropConnection forgets a connection once its OnConnect has returned.
+func (s *Server) dropConnection(conn chan bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-		//select {
-		//case <-onConnClose:
-		//	fmt.Println("connection close detected")
-		//	numOpen--
-		//}
+	for i, c := range s.openConnections {
+		if c == conn {
+			s.openConnections = append(s.openConnections[:i], s.openConnections[i+1:]...)
+			return
+		}
 	}
-	fmt.Println("closing server listenForConnections loop")
 }
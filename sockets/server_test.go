@@ -2,6 +2,13 @@
 
 package sockets
 
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
 const testSock = "/tmp/gomediaplayer_test.sock"
 
 //func TestInitSock(t *testing.T) {
@@ -59,3 +66,57 @@ const testSock = "/tmp/gomediaplayer_test.sock"
 //	t.Logf("nfd passed")
 //
 //}
+
+//TestConnectionsAreClosed makes sure each connection's fd is closed once OnConnect returns, however
+//many clients come and go.
+func TestConnectionsAreClosed(t *testing.T) {
+	returned := make(chan bool, 1)
+
+	srv := Server{
+		SockAddr: &unix.SockaddrUnix{Name: testSock},
+		OnConnect: func(cFD int, done chan bool) {
+			returned <- true
+		},
+	}
+
+	if err := srv.LaunchServer(); err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Unlink(testSock)
+
+	for i := 0; i < 20; i++ {
+		cfd, err := unix.Socket(unix.AF_LOCAL, unix.SOCK_STREAM, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err = unix.Connect(cfd, srv.SockAddr); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case <-returned:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("connection %d: OnConnect was never called", i)
+		}
+
+		//once the server closes its end, reading hits the end of the stream instead of waiting
+		if err = unix.SetsockoptTimeval(cfd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &unix.Timeval{Sec: 5}); err != nil {
+			t.Fatal(err)
+		}
+
+		n, err := unix.Read(cfd, make([]byte, 16))
+		_ = unix.Close(cfd)
+
+		if n != 0 || err != nil {
+			t.Fatalf("connection %d: expected the server to close the connection, read %d bytes with err %v", i, n, err)
+		}
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if open := len(srv.openConnections); open != 0 {
+		t.Fatalf("expected no open connections to be kept, got %d", open)
+	}
+}
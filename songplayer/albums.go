@@ -0,0 +1,147 @@
+package songplayer
+
+import (
+	"sort"
+)
+
+var albumMode bool
+
+//SetAlbumMode switches the shuffle between single songs and whole albums. In album mode albums
+//are picked by the average rank of their tracks, and their tracks play in order.
+func SetAlbumMode(on bool) {
+	albumMode = on
+}
+
+type album struct {
+	tracks []SongFile
+	pick   float64
+}
+
+//groupKey is what a song is kept out of batches by: the song itself, or its whole album in album mode.
+func groupKey(sF *SongFile) string {
+	if albumMode {
+		return sF.Album()
+	}
+
+	return sF.FileName
+}
+
+//groupLastHeard is the last time any song of each group was heard, keyed by groupKey.
+func (lib *SongLibrary) groupLastHeard() map[string]int64 {
+	heard := make(map[string]int64)
+	for i := range lib.Songs {
+		key := groupKey(&lib.Songs[i])
+		if h := lib.Songs[i].lastHeard(); h > heard[key] {
+			heard[key] = h
+		}
+	}
+
+	return heard
+}
+
+//admitAlbums lets the rest of an album in when any of its tracks match the playlist, so albums
+//don't play with holes in them. Banned and missing tracks still stay out. Returns how many songs
+//it admitted. Callers must hold lib.mu.
+func (lib *SongLibrary) admitAlbums() int {
+	if activePlaylist == nil {
+		return 0
+	}
+
+	matched := make(map[string]bool)
+	for i := range lib.Songs {
+		if lib.Songs[i].eligible {
+			matched[lib.Songs[i].Album()] = true
+		}
+	}
+
+	var admitted int
+	for i := range lib.Songs {
+		sF := &lib.Songs[i]
+		if sF.Breakdown.Excluded == "not in the playlist" && matched[sF.Album()] {
+			sF.Breakdown.Excluded = ""
+			sF.eligible = true
+			admitted++
+		}
+	}
+
+	return admitted
+}
+
+//byTrack orders an album's songs by disc, then track number, then file name.
+type byTrack []SongFile
+
+func (b byTrack) Len() int      { return len(b) }
+func (b byTrack) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byTrack) Less(i, j int) bool {
+	if b[i].Tags.Disc != b[j].Tags.Disc {
+		return b[i].Tags.Disc < b[j].Tags.Disc
+	}
+
+	if b[i].Tags.Track != b[j].Tags.Track {
+		return b[i].Tags.Track < b[j].Tags.Track
+	}
+
+	return b[i].FileName < b[j].FileName
+}
+
+//orderByAlbum lays the library out album by album, best picked album first, leaving songs that
+//can't be played at the end. Returns how many songs it takes to play whole albums until at least
+//maxSize songs are lined up. Callers must hold lib.mu and have seeded lib.rng.
+func (lib *SongLibrary) orderByAlbum() int {
+	byName := make(map[string]*album)
+	var albums []*album
+	var excluded []SongFile
+
+	for _, sF := range lib.Songs {
+		if !sF.eligible {
+			excluded = append(excluded, sF)
+			continue
+		}
+
+		key := sF.Album()
+		a, ok := byName[key]
+		if !ok {
+			a = &album{}
+			byName[key] = a
+			albums = append(albums, a)
+		}
+
+		a.tracks = append(a.tracks, sF)
+	}
+
+	//albums are drawn the same way songs are, from the average rank of their tracks
+	var scale float64
+	if temperature > 0 {
+		scale = temperature * lib.rankStdDev()
+	}
+
+	for _, a := range albums {
+		var total float64
+		for _, t := range a.tracks {
+			total += t.rank
+		}
+
+		a.pick = total / float64(len(a.tracks))
+		if scale > 0 {
+			a.pick += scale * lib.gumbel()
+		}
+
+		sort.Sort(byTrack(a.tracks))
+	}
+
+	sort.SliceStable(albums, func(i, j int) bool { return albums[i].pick > albums[j].pick })
+
+	songs := lib.Songs[:0:0]
+	batchSize := 0
+
+	for _, a := range albums {
+		songs = append(songs, a.tracks...)
+
+		if batchSize < maxSize {
+			batchSize = len(songs)
+		}
+	}
+
+	lib.Songs = append(songs, excluded...)
+	return batchSize
+}
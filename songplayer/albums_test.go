@@ -0,0 +1,220 @@
+package songplayer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAlbumKey(t *testing.T) {
+	song := func(artist, albumArtist, album string) *SongFile {
+		return &SongFile{
+			FileName: "/test/" + artist + "/" + album + "/track.mp3",
+			Tags:     Tags{Artist: artist, AlbumArtist: albumArtist, Album: album},
+		}
+	}
+
+	//the tracks of a compilation stay together, whoever performs them
+	if a, b := song("Miles Davis", "Various Artists", "Jazz Hits").Album(), song("Nina Simone", "Various Artists", "Jazz Hits").Album(); a != b {
+		t.Errorf("expected a compilation to be one album, got %q and %q", a, b)
+	}
+
+	//albums with the same title by different artists are kept apart
+	if a, b := song("Queen", "Queen", "Greatest Hits").Album(), song("ABBA", "ABBA", "Greatest Hits").Album(); a == b {
+		t.Errorf("expected albums by different artists to be kept apart, both are %q", a)
+	}
+
+	//without an album artist, the track artist is used
+	if a, b := song("Queen", "", "Greatest Hits").Album(), song("Queen", "Queen", "Greatest Hits").Album(); a != b {
+		t.Errorf("expected the track artist to stand in for the album artist, got %q and %q", a, b)
+	}
+
+	if got := song("Queen", "", "").Album(); got != "/test/Queen" {
+		t.Errorf("expected an untagged album to fall back to its directory, got %q", got)
+	}
+}
+
+func TestAlbumPlaysInDiscAndTrackOrder(t *testing.T) {
+	songs := testSongs(4)
+	for i := range songs {
+		songs[i].Tags.AlbumArtist = "Various Artists"
+		songs[i].Tags.Artist = songs[i].FileName
+		songs[i].eligible = true
+	}
+
+	songs[0].Tags.Disc, songs[0].Tags.Track = 2, 1
+	songs[1].Tags.Disc, songs[1].Tags.Track = 1, 2
+	songs[2].Tags.Disc, songs[2].Tags.Track = 2, 2
+	songs[3].Tags.Disc, songs[3].Tags.Track = 1, 1
+
+	defer useLibrary(songs)()
+
+	lib.mu.Lock()
+	lib.seedCompute()
+	n := lib.orderByAlbum()
+	lib.mu.Unlock()
+
+	if n != 4 {
+		t.Fatalf("expected the one album to make up the batch, got %d songs", n)
+	}
+
+	for i, want := range [][2]int{{1, 1}, {1, 2}, {2, 1}, {2, 2}} {
+		if got := lib.Songs[i].Tags; got.Disc != want[0] || got.Track != want[1] {
+			t.Fatalf("song %d: expected disc %d track %d, got disc %d track %d", i, want[0], want[1], got.Disc, got.Track)
+		}
+	}
+}
+
+func TestLoadAlbumTags(t *testing.T) {
+	dir, err := ioutil.TempDir("", "songplayer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tags := map[string][]byte{
+		"v2.2.mp3": id3Tag(2, 0, concat(
+			id3Frame(2, "TP1", 0, latin1("Nina Simone")),
+			id3Frame(2, "TP2", 0, latin1("Various Artists")),
+			id3Frame(2, "TPA", 0, latin1("2/2")),
+			id3Frame(2, "TRK", 0, latin1("7/12")),
+		)),
+		"v2.3.mp3": id3Tag(3, 0, concat(
+			id3Frame(3, "TPE1", 0, latin1("Nina Simone")),
+			id3Frame(3, "TPE2", 0, latin1("Various Artists")),
+			id3Frame(3, "TPOS", 0, latin1("2")),
+			id3Frame(3, "TRCK", 0, latin1(" 7")),
+		)),
+	}
+
+	for name, tag := range tags {
+		sF := SongFile{FileName: filepath.Join(dir, name)}
+		if err = ioutil.WriteFile(sF.FileName, tag, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if err = sF.loadTags(); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := sF.Tags; got.AlbumArtist != "Various Artists" || got.Disc != 2 || got.Track != 7 {
+			t.Errorf("%s: expected album artist Various Artists, disc 2 and track 7, got %+v", name, got)
+		}
+	}
+}
+
+//computeAlbums runs a compute over the library of three ten track albums, with the
+//fourth track of the first album heard an hour ago.
+func computeAlbums() {
+	at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return at }
+
+	lib.Songs[3].LastPlayed = at.Add(-time.Hour).Unix()
+	lib.Songs[3].TotalPlays = 1
+
+	lib.mu.Lock()
+	lib.seedCompute()
+	lib.compute()
+	lib.mu.Unlock()
+}
+
+//expectExcluded checks which songs the last compute kept out, and why.
+func expectExcluded(t *testing.T, name string, want func(i int) string) {
+	t.Helper()
+
+	byName := make(map[string]*SongFile)
+	for i := range lib.Songs {
+		byName[lib.Songs[i].FileName] = &lib.Songs[i]
+	}
+
+	for i, sF := range testSongs(len(lib.Songs)) {
+		if got := byName[sF.FileName].Breakdown.Excluded; got != want(i) {
+			t.Fatalf("%s: expected song %d to be excluded for %q, got %q", name, i, want(i), got)
+		}
+	}
+}
+
+func TestAlbumModeExcludesWholeAlbums(t *testing.T) {
+	defer SetAlbumMode(false)
+	defer SetNoRepeatWindow(NoRepeatWindow{})
+	SetAlbumMode(true)
+	SetNoRepeatWindow(NoRepeatWindow{Songs: 1})
+
+	firstAlbum := func(reason string) func(i int) string {
+		return func(i int) string {
+			if i < 10 {
+				return reason
+			}
+
+			return ""
+		}
+	}
+
+	restore := useLibrary(testSongs(30))
+	SetPlaylistMaxSize(10)
+	computeAlbums()
+	expectExcluded(t, "no-repeat window", firstAlbum("no-repeat window"))
+
+	for _, sF := range lib.Batch() {
+		if sF.Tags.Album == "album0" {
+			t.Fatalf("expected the album heard within the window to sit out, got %s in the batch", sF.FileName)
+		}
+	}
+	restore()
+
+	//the window gives way to whole albums when there aren't enough songs left to fill the batch
+	restore = useLibrary(testSongs(30))
+	SetPlaylistMaxSize(25)
+	computeAlbums()
+	expectExcluded(t, "shrunk no-repeat window", firstAlbum(""))
+	restore()
+
+	SetNoRepeatWindow(NoRepeatWindow{})
+	SetDiscovery(Discovery{Coverage: true})
+	defer SetDiscovery(Discovery{})
+
+	restore = useLibrary(testSongs(30))
+	lib.CycleStart = 1
+	computeAlbums()
+	expectExcluded(t, "coverage", firstAlbum("heard this coverage cycle"))
+	restore()
+}
+
+func TestAlbumModeAdmitsWholeAlbums(t *testing.T) {
+	defer SetAlbumMode(false)
+	defer UsePlaylist("")
+
+	if err := SetSmartPlaylists(map[string]string{"openers": "track = 1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := UsePlaylist("openers"); err != nil {
+		t.Fatal(err)
+	}
+
+	//songs are kept out one by one
+	defer useLibrary(testSongs(30))()
+	computeAlbums()
+	expectExcluded(t, "song mode", func(i int) string {
+		if i%10 == 0 {
+			return ""
+		}
+
+		return "not in the playlist"
+	})
+
+	//while albums come in whole, apart from the tracks that can't be played
+	SetAlbumMode(true)
+	lib.Songs = testSongs(30)
+	lib.Songs[15].Banned = true
+	computeAlbums()
+	expectExcluded(t, "album mode", func(i int) string {
+		if i == 15 {
+			return "never play"
+		}
+
+		return ""
+	})
+}
@@ -0,0 +1,90 @@
+package songplayer
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+//idleRetry is how long the player waits before computing again, when there's nothing it can play:
+//every song is missing its file, banned or otherwise excluded.
+const idleRetry = 30 * time.Second
+
+//checkAvailability marks the songs whose files have gone missing as unavailable, and makes those
+//that have come back available again. Missing songs keep everything they've built up, so a drive
+//that wasn't mounted picks up where it left off. Callers must hold lib.mu.
+func (lib *SongLibrary) checkAvailability() {
+	var lost, found int
+
+	//a missing root takes every song under it, so there's no need to look at each file
+	_, rootErr := os.Stat(libDir)
+
+	for i := range lib.Songs {
+		sF := &lib.Songs[i]
+
+		missing := libDir != "" && rootErr != nil
+		if !missing {
+			info, err := os.Stat(sF.FileName)
+			missing = err != nil
+
+			//a file that couldn't be decoded gets another chance once it's been replaced
+			if err == nil && sF.Undecodable != 0 && info.ModTime().UnixNano() != sF.Undecodable {
+				sF.Undecodable = 0
+			}
+		}
+
+		if missing && !sF.Unavailable {
+			lost++
+		} else if !missing && sF.Unavailable {
+			found++
+		}
+
+		sF.Unavailable = missing
+	}
+
+	if lost > 0 {
+		fmt.Printf("%d songs are missing their files and won't be played until they're back\n", lost)
+	}
+
+	if found > 0 {
+		fmt.Printf("%d missing songs are available again\n", found)
+	}
+}
+
+//markUnavailable takes a song whose file couldn't be opened out of play.
+func (sF *SongFile) markUnavailable(err error) {
+	fmt.Printf("unable to open %s, marking it unavailable: %v\n", sF.FileName, err)
+
+	lib.mu.Lock()
+	sF.Unavailable = true
+	lib.mu.Unlock()
+}
+
+//markUndecodable takes a song whose file couldn't be decoded out of play, until the file changes.
+func (sF *SongFile) markUndecodable(err error) {
+	info, statErr := os.Stat(sF.FileName)
+	if statErr != nil {
+		sF.markUnavailable(statErr)
+		return
+	}
+
+	fmt.Printf("unable to play %s, leaving it out until the file changes: %v\n", sF.FileName, err)
+
+	lib.mu.Lock()
+	sF.Undecodable = info.ModTime().UnixNano()
+	lib.mu.Unlock()
+}
+
+//anyUnavailable reports whether any song is waiting on its file to come back.
+func (lib *SongLibrary) anyUnavailable() bool {
+	lib.mu.RLock()
+	defer lib.mu.RUnlock()
+
+	for i := range lib.Songs {
+		if lib.Songs[i].Unavailable {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,76 @@
+package songplayer
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestUndecodableSongSitsOut(t *testing.T) {
+	defer usePlaybackLibrary(t, 3, 8*time.Second)()
+
+	//the file opens, but isn't an mp3
+	name := lib.Songs[0].FileName
+	if err := ioutil.WriteFile(name, bytes.Repeat([]byte("not an mp3 "), 200), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if lib.Songs[0].play() {
+		t.Fatal("expected the player to carry on")
+	}
+
+	undecodable := func() int64 {
+		lib.mu.RLock()
+		defer lib.mu.RUnlock()
+
+		return lib.findSong(name).Undecodable
+	}
+
+	if undecodable() == 0 {
+		t.Fatal("expected the song to be marked undecodable")
+	}
+
+	//it's left out of every batch from then on
+	for i := 0; i < 6; i++ {
+		if sF := lib.nextSong(); sF.FileName == name {
+			t.Fatalf("expected the undecodable song to sit out, got it after %d songs", i)
+		}
+	}
+
+	if got := playInfo(name); got.TotalPlays != 0 || got.TotalSkips != 0 {
+		t.Fatalf("expected the song not to count as heard, got %d plays and %d skips", got.TotalPlays, got.TotalSkips)
+	}
+
+	lib.mu.Lock()
+	excluded := lib.findSong(name).Breakdown.Excluded
+	lib.checkAvailability()
+	lib.mu.Unlock()
+
+	if excluded != "can't be decoded" {
+		t.Fatalf("expected the song to be excluded as undecodable, got %q", excluded)
+	}
+
+	if undecodable() == 0 {
+		t.Fatal("expected the song to stay undecodable while its file is unchanged")
+	}
+
+	//until the file is replaced
+	if err := writeSilentMP3(name, 8*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(name, later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	lib.mu.Lock()
+	lib.checkAvailability()
+	lib.mu.Unlock()
+
+	if undecodable() != 0 {
+		t.Fatal("expected the replaced file to get another chance")
+	}
+}
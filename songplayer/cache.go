@@ -5,14 +5,27 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"strconv"
+	"sync"
 )
 
 var lib *SongLibrary
 
 const cacheName = "songlib.cache"
 
+//cacheVersion is bumped whenever the cache layout changes in a way that needs migrating.
+const cacheVersion = 5
+
+//persistMu keeps the player and queue edits from writing the cache over each other
+var persistMu sync.Mutex
+
 func (lib *SongLibrary) persistSelf() {
+	persistMu.Lock()
+	defer persistMu.Unlock()
+
+	lib.mu.RLock()
 	res, err := json.MarshalIndent(lib, "", "  ")
+	lib.mu.RUnlock()
 	if err != nil {
 		panic(err)
 	}
@@ -47,6 +60,8 @@ func GetLibrary() *SongLibrary {
 	res, err := ioutil.ReadFile(cacheName)
 	if err == nil {
 		if err = json.Unmarshal(res, lib); err == nil {
+			lib.migrate(res)
+			lib.measureAlbums()
 			return lib
 		}
 	}
@@ -56,7 +71,98 @@ func GetLibrary() *SongLibrary {
 	}
 
 	fmt.Println("Library cache not found - loading from library dir")
+	lib.Version = cacheVersion
+	lib.Volume = 100
 	lib.LoadFromFiles()
 
 	return lib
 }
+
+//ReadLibrary loads a library cache as it was saved, without scanning or computing anything.
+func ReadLibrary(file string) (*SongLibrary, error) {
+	if file == "" {
+		file = cacheName
+	}
+
+	res, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &SongLibrary{}
+	if err = json.Unmarshal(res, l); err != nil {
+		return nil, err
+	}
+
+	lib = l
+	lib.migrate(res)
+
+	return lib, nil
+}
+
+//migrate brings a library loaded from an older cache up to cacheVersion.
+func (lib *SongLibrary) migrate(raw []byte) {
+	if lib.Version >= cacheVersion {
+		return
+	}
+
+	fmt.Printf("migrating library cache from version [%d] to [%d]\n", lib.Version, cacheVersion)
+
+	if lib.Version < 1 {
+		lib.migrateUnsignedScores(raw)
+	}
+
+	//tags were first read in version 2, their ReplayGain values in version 4 and album artists
+	//and discs in version 5. Songs without ReplayGain tags are measured in the background once
+	//playback starts.
+	if lib.Version < 5 {
+		fmt.Println("reading tags for the cached library")
+		for i := range lib.Songs {
+			_ = lib.Songs[i].loadTags()
+		}
+	}
+
+	//caches from before the volume control played everything at full volume
+	if lib.Version < 3 {
+		lib.Volume = 100
+	}
+
+	lib.Version = cacheVersion
+}
+
+//migrateUnsignedScores re-reads scores that were stored as uint64. Penalties used to wrap those
+//around to enormous values, so they're reinterpreted as the signed values they were meant to be.
+func (lib *SongLibrary) migrateUnsignedScores(raw []byte) {
+	var old struct {
+		Songs []struct {
+			Score json.Number `json:"score"`
+		} `json:"songs"`
+	}
+
+	if err := json.Unmarshal(raw, &old); err != nil {
+		panic(err)
+	}
+
+	lib.TotalScore = 0
+
+	for i := 0; i < len(lib.Songs) && i < len(old.Songs); i++ {
+		if old.Songs[i].Score == "" {
+			continue
+		}
+
+		u, err := strconv.ParseUint(old.Songs[i].Score.String(), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		lib.Songs[i].Score = float64(int64(u))
+
+		if lib.Songs[i].Score > 0 {
+			lib.TotalScore += lib.Songs[i].Score
+		}
+	}
+
+	if len(lib.Songs) > 0 {
+		lib.AvgScore = lib.TotalScore / float64(len(lib.Songs))
+	}
+}
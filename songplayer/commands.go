@@ -0,0 +1,113 @@
+package songplayer
+
+import (
+	"fmt"
+	"path"
+)
+
+//Command is an instruction for the player, sent from the ui or over the socket.
+type Command struct {
+	Signal int64  `json:"signal"`
+	Value  int64  `json:"value,omitempty"`  //Value is a rating, flag, queue position, or for seeks, milliseconds
+	Target string `json:"target,omitempty"` //Target is the song the command applies to, the playing song when empty
+}
+
+//HandleCommand carries out a command received from a client. Playback signals are forwarded to the
+//play loop, so this may block until the player is ready to receive them.
+func HandleCommand(cmd Command) {
+	switch cmd.Signal {
+	case SignalRate, SignalFavourite, SignalBan:
+		banned, err := lib.setPreference(cmd)
+		if err != nil {
+			fmt.Println("unable to handle command: " + err.Error())
+			return
+		}
+
+		//There's no point listening out the rest of a song we never want to hear
+		if banned {
+			PlayerSignal <- Command{Signal: SignalSkip}
+		}
+	case SignalEnqueue, SignalPlayNext, SignalDequeue, SignalMoveQueued:
+		if err := lib.editQueue(cmd); err != nil {
+			fmt.Println("unable to handle command: " + err.Error())
+			return
+		}
+
+		lib.persistSelf()
+	case SignalVolume, SignalSetVolume, SignalMute:
+		if err := lib.setVolume(cmd); err != nil {
+			fmt.Println("unable to handle command: " + err.Error())
+			return
+		}
+
+		lib.persistSelf()
+	default:
+		PlayerSignal <- cmd
+	}
+}
+
+//findSong looks up a song by its full file name, or failing that, its base name. An empty name
+//finds the song that's playing. Callers must hold lib.mu.
+func (lib *SongLibrary) findSong(name string) *SongFile {
+	if name == "" {
+		name, _ = nowPlaying.Load().(string)
+	}
+
+	if name == "" {
+		return nil
+	}
+
+	for i := range lib.Songs {
+		if lib.Songs[i].FileName == name {
+			return &lib.Songs[i]
+		}
+	}
+
+	for i := range lib.Songs {
+		if path.Base(lib.Songs[i].FileName) == name {
+			return &lib.Songs[i]
+		}
+	}
+
+	return nil
+}
+
+//setPreference applies a rating, favourite or ban command. Returns true when the playing song was
+//just banned.
+func (lib *SongLibrary) setPreference(cmd Command) (bannedPlaying bool, err error) {
+	lib.mu.Lock()
+	defer lib.mu.Unlock()
+
+	sF := lib.findSong(cmd.Target)
+	if sF == nil {
+		return false, fmt.Errorf("no song found matching [%s]", cmd.Target)
+	}
+
+	switch cmd.Signal {
+	case SignalRate:
+		if cmd.Value < 0 || cmd.Value > 5 {
+			return false, fmt.Errorf("rating must be between 1 and 5 stars, or 0 to clear it. got: %d", cmd.Value)
+		}
+		sF.Rating = uint8(cmd.Value)
+	case SignalFavourite:
+		sF.Favourite = cmd.Value != 0
+	case SignalBan:
+		sF.Banned = cmd.Value != 0
+		playing, _ := nowPlaying.Load().(string)
+		bannedPlaying = sF.Banned && sF.FileName == playing
+	}
+
+	return bannedPlaying, nil
+}
+
+//refreshState copies the song's rating and flags, and the queue, into the state sent to the ui.
+func (sF *SongFile) refreshState() {
+	lib.mu.RLock()
+	sF.playingSong.Rating = sF.Rating
+	sF.playingSong.Favourite = sF.Favourite
+	sF.playingSong.Banned = sF.Banned
+	sF.playingSong.Queue = lib.queued()
+	sF.playingSong.Volume = lib.Volume
+	sF.playingSong.Muted = lib.Muted
+	lib.mu.RUnlock()
+}
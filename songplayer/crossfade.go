@@ -0,0 +1,106 @@
+package songplayer
+
+import (
+	"time"
+
+	"github.com/faiface/beep"
+	"github.com/faiface/beep/effects"
+)
+
+//fadeStep is how many samples play between gain adjustments during a crossfade.
+const fadeStep = 512
+
+var crossfade time.Duration
+
+//SetCrossfade sets how long the end of each song overlaps the start of the next. 0 turns
+//crossfading off, leaving songs to play back to back.
+func SetCrossfade(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+
+	crossfade = d
+}
+
+//fade mixes the end of one song into the start of the next.
+type fade struct {
+	out       *track
+	mix       beep.Mixer
+	outGain   *effects.Gain
+	inGain    *effects.Gain
+	pos, span int
+}
+
+//fadeIn reports how many samples the current song can play before it should start fading into the
+//next one, or -1 when there won't be a crossfade. Callers must hold the output's lock.
+func (d *deck) fadeIn() int {
+	if crossfade == 0 || d.next == nil || d.fading != nil {
+		return -1
+	}
+
+	left := d.cur.remaining() - outputRate.N(crossfade)
+	if left < 0 {
+		return 0
+	}
+
+	return left
+}
+
+//beginFade starts the next song under the end of the current one. Callers must hold the output's
+//lock.
+func (d *deck) beginFade() {
+	out, in := d.cur, d.next
+
+	f := &fade{
+		out:     out,
+		outGain: &effects.Gain{Streamer: out.stream},
+		inGain:  &effects.Gain{Streamer: in.stream, Gain: -1},
+		span:    out.remaining(),
+	}
+	if f.span < 1 {
+		f.span = 1
+	}
+	f.mix.Add(f.outGain, f.inGain)
+
+	d.fading = f
+	d.cur, d.next = in, nil
+	in.started = time.Now()
+	out.fadedOut = in.started
+}
+
+//streamFade plays the crossfade into samples, moving the gains a step at a time, and finishes the
+//outgoing song once it runs out. Returns how many samples it filled. Callers must hold the
+//output's lock.
+func (d *deck) streamFade(samples [][2]float64) int {
+	f := d.fading
+
+	n := len(samples)
+	if n > fadeStep {
+		n = fadeStep
+	}
+
+	progress := float64(f.pos) / float64(f.span)
+	if progress > 1 {
+		progress = 1
+	}
+
+	//the Gain effects multiply by 1+Gain
+	f.outGain.Gain = -progress
+	f.inGain.Gain = progress - 1
+
+	f.mix.Stream(samples[:n])
+	f.pos += n
+
+	if f.pos >= f.span || f.out.s.Position() >= f.out.s.Len() {
+		d.endFade()
+	}
+
+	return n
+}
+
+//endFade finishes the outgoing song, leaving the incoming one at full volume. Callers must hold the
+//output's lock.
+func (d *deck) endFade() {
+	d.finished(d.fading.out)
+	d.fading = nil
+}
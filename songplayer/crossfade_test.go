@@ -0,0 +1,129 @@
+package songplayer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/faiface/beep"
+)
+
+//useCrossfade turns crossfading on for a test. The null sink may still be streaming the deck from
+//an earlier one, so it's set under the output's lock. Returns a func turning it back off.
+func useCrossfade(t *testing.T, d time.Duration) func() {
+	useNullOutput(t)
+
+	set := func(d time.Duration) {
+		output.Lock()
+		SetCrossfade(d)
+		output.Unlock()
+	}
+
+	set(d)
+	return func() { set(0) }
+}
+
+func TestCrossfadeRamp(t *testing.T) {
+	defer useCrossfade(t, 100*time.Millisecond)()
+
+	//the outgoing song at full scale, the incoming one at half
+	span := outputRate.N(crossfade)
+	a, b := levelTrack("a", 1, 10000), levelTrack("b", 0.5, 20000)
+
+	var d deck
+	d.cur, d.next = a, b
+
+	var played [][2]float64
+	samples := make([][2]float64, 1000)
+	for i := 0; i < 30; i++ {
+		d.Stream(samples)
+		played = append(played, samples...)
+	}
+
+	//the outgoing song plays alone until the fade begins
+	fadeAt := 10000 - span
+	expectLevels(t, played[:fadeAt], run{1, fadeAt})
+
+	//then the mix slides towards the incoming song, a step at a time
+	for i := fadeAt + 1; i < 10000; i++ {
+		if played[i][0] > played[i-1][0] || played[i][0] < 0.5 {
+			t.Fatalf("sample %d: expected the mix to fall towards 0.5, went from %v to %v", i, played[i-1][0], played[i][0])
+		}
+	}
+
+	if first, last := played[fadeAt][0], played[9999][0]; first != 1 || last > 0.5+float64(fadeStep)/float64(span)/2 {
+		t.Fatalf("expected the fade to run from 1 to 0.5, ran from %v to %v", first, last)
+	}
+
+	//and once the outgoing song has run out, the incoming one plays on by itself, at full volume
+	expectLevels(t, played[10000:], run{0.5, 20000 - span}, run{0, span})
+
+	//the outgoing song is reported as played through when the fade ends
+	awaitDone(t, "a", "b")
+
+	if d.fading != nil || !a.s.(*levelSong).closed {
+		t.Fatal("expected the fade to be over, and the outgoing song closed")
+	}
+
+	if a.fadedOut.IsZero() || a.fadedOut != b.started {
+		t.Fatal("expected the outgoing song to have faded out when the incoming one started")
+	}
+}
+
+func TestCrossfadeCountedOnce(t *testing.T) {
+	defer useLibrary(testSongs(1))()
+	useNullOutput(t)
+
+	//the song began ten seconds ago, and the next one started fading in over it three seconds ago
+	tr := levelTrack("a", 0.5, 100)
+	tr.fadedOut = time.Now().Add(-3 * time.Second)
+	playStart.Store(time.Now().Add(-10 * time.Second))
+
+	sF := &lib.Songs[0]
+	sF.onFinish(&beep.Ctrl{}, tr, false, 0)
+
+	if got := lib.TimePlayed; got < 7*time.Second || got > 7*time.Second+time.Second/2 {
+		t.Fatalf("expected the song to count the 7 seconds until the fade, got %v", got)
+	}
+}
+
+func TestPlaybackCrossfades(t *testing.T) {
+	defer usePlaybackLibrary(t, 3, 8*time.Second)()
+	defer useCrossfade(t, 2*time.Second)()
+
+	w := watchState()
+	defer w.close()
+
+	first := player.upNext()
+	name := first.FileName
+	awaitPlay(t, startPlaying(first))
+
+	//the next song was already playing when the first finished fading out
+	next := player.upNext()
+
+	output.Lock()
+	cur := player.cur
+	var pos time.Duration
+	if cur != nil {
+		pos = cur.position()
+	}
+	output.Unlock()
+
+	if cur == nil || cur.name != next.FileName {
+		t.Fatalf("expected %s to have faded in", next.FileName)
+	}
+
+	if pos < 3*crossfade/4 {
+		t.Fatalf("expected %s to have played through the crossfade, got to %v", next.FileName, pos)
+	}
+
+	if pI := playInfo(name); pI.TotalPlays != 1 || pI.TotalSkips != 0 {
+		t.Fatalf("expected the song faded out to count as played, got %d plays and %d skips", pI.TotalPlays, pI.TotalSkips)
+	}
+
+	//and plays on from where it got to
+	awaitPlay(t, startPlaying(next))
+
+	if pI := playInfo(next.FileName); pI.TotalPlays != 1 || pI.TotalSkips != 0 {
+		t.Fatalf("expected the song faded in to count as played, got %d plays and %d skips", pI.TotalPlays, pI.TotalSkips)
+	}
+}
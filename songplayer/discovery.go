@@ -0,0 +1,112 @@
+package songplayer
+
+//Discovery makes sure songs that have hardly been heard get their turn.
+type Discovery struct {
+	//Ratio is the share of every batch kept for undiscovered songs, 0.2 being 1 song in 5.
+	Ratio float64 `json:"ratio"`
+
+	//MaxPlays is how many times a song can be played and still count as undiscovered.
+	MaxPlays uint64 `json:"max_plays"`
+
+	//Coverage plays every eligible song once before any song repeats.
+	Coverage bool `json:"coverage"`
+}
+
+var discovery Discovery
+
+//SetDiscovery configures how hard the shuffle pushes songs that haven't been heard much.
+func SetDiscovery(d Discovery) {
+	if d.Ratio < 0 {
+		d.Ratio = 0
+	} else if d.Ratio > 1 {
+		d.Ratio = 1
+	}
+
+	discovery = d
+}
+
+//undiscovered reports whether the song belongs to the discovery pool.
+func (pI *PlayInfo) undiscovered() bool {
+	return pI.TotalPlays <= discovery.MaxPlays
+}
+
+//promoteDiscoveries walks the upcoming batch, and whenever fewer than Ratio of the songs so far are
+//undiscovered, pulls the best picked undiscovered song from further down the first poolSize songs
+//into place. Callers must hold lib.mu, with the library sorted.
+func (lib *SongLibrary) promoteDiscoveries(batchSize, poolSize int) {
+	if discovery.Ratio == 0 {
+		return
+	}
+
+	if poolSize > len(lib.Songs) {
+		poolSize = len(lib.Songs)
+	}
+
+	have := 0
+	for i := 0; i < batchSize && i < poolSize; i++ {
+		if lib.Songs[i].undiscovered() {
+			have++
+			continue
+		}
+
+		if have >= int(float64(i+1)*discovery.Ratio) {
+			continue
+		}
+
+		for j := i + 1; j < poolSize; j++ {
+			if !lib.Songs[j].undiscovered() {
+				continue
+			}
+
+			//shift everything in between down one, so the rest keeps its order
+			sF := lib.Songs[j]
+			copy(lib.Songs[i+1:j+1], lib.Songs[i:j])
+			lib.Songs[i] = sF
+			have++
+			break
+		}
+	}
+}
+
+//excludeCovered marks the eligible songs already heard in this coverage cycle as ineligible, and
+//returns how many it excluded. Once every eligible song has been heard, a new cycle begins. In
+//album mode, hearing any track covers the whole album. Callers must hold lib.mu.
+func (lib *SongLibrary) excludeCovered() int {
+	if !discovery.Coverage {
+		return 0
+	}
+
+	heard := lib.groupLastHeard()
+
+	uncovered := func() int {
+		n := 0
+		for i := range lib.Songs {
+			if lib.Songs[i].eligible && heard[groupKey(&lib.Songs[i])] < lib.CycleStart {
+				n++
+			}
+		}
+
+		return n
+	}
+
+	if uncovered() == 0 {
+		//the song that just finished counts towards the new cycle, so it can't open it
+		lib.CycleStart = now().Unix()
+
+		if uncovered() == 0 {
+			return 0
+		}
+	}
+
+	excluded := 0
+	for i := range lib.Songs {
+		sF := &lib.Songs[i]
+		if sF.eligible && heard[groupKey(sF)] >= lib.CycleStart {
+			sF.eligible = false
+			sF.Breakdown.Excluded = "heard this coverage cycle"
+			excluded++
+		}
+	}
+
+	return excluded
+}
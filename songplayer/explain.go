@@ -0,0 +1,64 @@
+package songplayer
+
+import (
+	"fmt"
+)
+
+//ScoreBreakdown records what each part of scoring contributed to a song on the latest compute.
+type ScoreBreakdown struct {
+	Computed      int64   `json:"computed,omitempty"`
+	Previous      float64 `json:"previous_score"`            //Previous is the score carried over from the compute before
+	NewSongJitter float64 `json:"new_song_jitter,omitempty"` //NewSongJitter is the head start given to songs without a score
+	Jitter        float64 `json:"jitter"`
+	SkipPenalty   float64 `json:"skip_penalty,omitempty"`
+	SkipRecovery  float64 `json:"skip_recovery,omitempty"` //SkipRecovery is how much of the outstanding skip penalty wore off
+	Staleness     float64 `json:"staleness_bonus,omitempty"`
+	AvgAdjustment float64 `json:"avg_adjustment,omitempty"` //AvgAdjustment is taken from songs that were just played
+	Score         float64 `json:"score"`
+	Preference    float64 `json:"preference,omitempty"`
+	TimeContext   float64 `json:"time_context,omitempty"`
+	Multiplier    float64 `json:"multiplier,omitempty"` //Multiplier is the product of the weight rules covering the song, applied to the rank
+	Rank          float64 `json:"rank"`
+	Pick          float64 `json:"pick,omitempty"`     //Pick is the rank after the temperature's noise, which batches are ordered by
+	Excluded      string  `json:"excluded,omitempty"` //Excluded is why the song was kept out of the batch, if it was
+}
+
+//Explanation is a song's score breakdown, along with where it stands in the current batch.
+type Explanation struct {
+	Song string `json:"song"`
+	ScoreBreakdown
+
+	//BatchPosition counts from 1, and is 0 when the song isn't in the current batch.
+	BatchPosition int  `json:"batch_position,omitempty"`
+	BatchSize     int  `json:"batch_size"`
+	Played        bool `json:"played,omitempty"` //Played is true once the batch has moved past the song
+}
+
+//Explain looks up why a song scored and placed the way it did on the latest compute. An empty name
+//explains the playing song.
+func (lib *SongLibrary) Explain(name string) (Explanation, error) {
+	lib.mu.RLock()
+	defer lib.mu.RUnlock()
+
+	sF := lib.findSong(name)
+	if sF == nil {
+		return Explanation{}, fmt.Errorf("no song found matching [%s]", name)
+	}
+
+	e := Explanation{Song: sF.FileName, ScoreBreakdown: sF.Breakdown, BatchSize: lib.BatchSize}
+
+	for i := 0; i < lib.BatchSize && i < len(lib.Songs); i++ {
+		if &lib.Songs[i] == sF {
+			e.BatchPosition = i + 1
+			e.Played = i < lib.NextSong
+			break
+		}
+	}
+
+	return e, nil
+}
+
+//ExplainSong explains a song in the library the player is using.
+func ExplainSong(name string) (Explanation, error) {
+	return lib.Explain(name)
+}
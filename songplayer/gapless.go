@@ -0,0 +1,406 @@
+package songplayer
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/faiface/beep"
+	"github.com/faiface/beep/effects"
+	"github.com/faiface/beep/mp3"
+)
+
+//primeAhead is how long before the end of a song the next one is opened and lined up.
+const primeAhead = 10 * time.Second
+
+//track is a song opened for playback.
+type track struct {
+	name     string
+	s        beep.StreamSeekCloser //s is the decoder, at the song's own sample rate
+	stream   beep.Streamer         //stream is what's played, resampled to the output's rate
+	format   beep.Format
+	gain     float64   //gain scales the song to the normalization target
+	started  time.Time //started is when the track began to play, zero until it does
+	fadedOut time.Time //fadedOut is when the next song began fading in over this one, zero until it does
+}
+
+//position is how far into the song playback has got.
+func (t *track) position() time.Duration {
+	return t.format.SampleRate.D(t.s.Position())
+}
+
+//remaining is how many samples at the output's rate are left to play.
+func (t *track) remaining() int {
+	left := t.s.Len() - t.s.Position()
+	return int(float64(left) * float64(outputRate) / float64(t.format.SampleRate))
+}
+
+//deck streams songs back to back to the output. It stays in the output's mixer for as long as the
+//output is initialised, and when the current song runs out part way through a buffer, the rest of
+//the buffer comes from the next song, so there's no gap between them.
+type deck struct {
+	//cur, next and fading are guarded by the output's lock, since the output streams from the deck
+	cur, next *track
+	fading    *fade
+
+	ctrl   *beep.Ctrl
+	volume *effects.Volume //volume wraps ctrl, and is what the output plays
+
+	//primed is the song lined up to play next, and its track when it opened.
+	mu          sync.Mutex
+	priming     sync.WaitGroup
+	primed      *SongFile
+	primedTrack *track
+}
+
+var (
+	player deck
+
+	//trackDone receives the file name of each song the deck plays through to the end.
+	trackDone = make(chan string)
+)
+
+//Stream fills samples from the current song and then the next, crossfading between them when
+//that's turned on, and padding with silence once both have run out. It never drains, so the output
+//keeps it around between songs.
+func (d *deck) Stream(samples [][2]float64) (int, bool) {
+	filled := 0
+
+	for filled < len(samples) {
+		if d.fading != nil {
+			filled += d.streamFade(samples[filled:])
+			continue
+		}
+
+		if d.cur == nil {
+			break
+		}
+
+		//stop short where the crossfade should begin
+		chunk := samples[filled:]
+		if left := d.fadeIn(); left == 0 {
+			d.beginFade()
+			continue
+		} else if left > 0 && left < len(chunk) {
+			chunk = chunk[:left]
+		}
+
+		n, ok := d.cur.stream.Stream(chunk)
+		filled += n
+
+		if ok && n > 0 {
+			continue
+		}
+
+		d.finished(d.cur)
+		d.cur, d.next = d.next, nil
+		if d.cur != nil {
+			d.cur.started = time.Now()
+		}
+	}
+
+	for i := filled; i < len(samples); i++ {
+		samples[i] = [2]float64{}
+	}
+
+	return len(samples), true
+}
+
+//finished closes a track that played through to the end and lets its song know. That's done in the
+//background, since the output's lock is held and the play loop may be busy, but the song's play
+//loop only moves on once it hears, so it can't be dropped either.
+func (d *deck) finished(t *track) {
+	go func(name string) {
+		trackDone <- name
+	}(t.name)
+
+	_ = t.s.Close()
+}
+
+func (d *deck) Err() error {
+	return nil
+}
+
+//start makes t the song being played. Songs the deck already moved on to carry on as they are. The
+//output is opened the first time anything plays, and stays open from then on.
+func (d *deck) start(t *track) error {
+	output.Lock()
+	streaming := d.cur == t
+	output.Unlock()
+
+	if streaming {
+		return nil
+	}
+
+	t.started = time.Now()
+
+	if d.ctrl != nil {
+		output.Lock()
+		d.dropQueued(t)
+		d.cur = t
+		output.Unlock()
+		return nil
+	}
+
+	if err := output.Init(outputRate, outputRate.N(outputBuffer)); err != nil {
+		return err
+	}
+
+	d.ctrl = &beep.Ctrl{Streamer: d}
+	d.newVolume()
+
+	output.Lock()
+	d.cur = t
+	output.Unlock()
+
+	output.Play(d.volume)
+	return nil
+}
+
+//dropQueued closes whatever the deck was still holding on to, besides keep. Callers must hold the
+//output's lock.
+func (d *deck) dropQueued(keep *track) {
+	if d.fading != nil {
+		_ = d.fading.out.s.Close()
+		d.fading = nil
+	}
+
+	for _, t := range []*track{d.cur, d.next} {
+		if t != nil && t != keep {
+			_ = t.s.Close()
+		}
+	}
+
+	d.cur, d.next = nil, nil
+}
+
+//skip stops t and returns how far into it playback got. The next song starts straight away if it's
+//lined up. Skipping a song that's fading out just cuts the fade short.
+func (d *deck) skip(t *track) time.Duration {
+	output.Lock()
+	defer output.Unlock()
+
+	pos := t.position()
+
+	switch {
+	case d.fading != nil && d.fading.out == t:
+		_ = t.s.Close()
+		d.fading = nil
+	case d.cur == t:
+		_ = t.s.Close()
+		d.cur, d.next = d.next, nil
+		if d.cur != nil {
+			d.cur.started = time.Now()
+		}
+	}
+
+	return pos
+}
+
+//stop cuts t off without moving on to the next song.
+func (d *deck) stop(t *track) {
+	output.Lock()
+	defer output.Unlock()
+
+	if d.fading != nil && d.fading.out == t {
+		d.fading = nil
+	}
+
+	if d.cur == t {
+		d.cur = nil
+	}
+
+	_ = t.s.Close()
+}
+
+//seek moves playback of t to wherever to says, given where it is now. Songs that are already fading
+//out can't be moved.
+func (d *deck) seek(t *track, to func(pos time.Duration) time.Duration) {
+	output.Lock()
+	defer output.Unlock()
+
+	if d.cur != t || d.fading != nil {
+		return
+	}
+
+	//stop a second short of the end, so there's still something left to hear
+	n := t.format.SampleRate.N(to(t.position()))
+	if last := t.s.Len() - t.format.SampleRate.N(time.Second); n > last {
+		n = last
+	}
+
+	if n < 0 {
+		n = 0
+	}
+
+	if err := t.s.Seek(n); err != nil {
+		fmt.Printf("unable to seek %s: %v\n", t.name, err)
+		return
+	}
+
+	//the resampler holds on to samples from before the seek, so it starts over
+	t.buildStream()
+}
+
+//prime picks the next song and opens it, lining it up behind the current one. Runs alongside the
+//song that's playing, so picking may compute a new batch and reorder the library underneath it,
+//and there's time to measure the song's loudness first if it's never been measured.
+func (d *deck) prime() {
+	defer d.priming.Done()
+
+	sF := lib.nextSong()
+	sF.measureIfNeeded()
+	t, err := sF.initFile()
+
+	d.mu.Lock()
+	d.primed, d.primedTrack = sF, t
+	d.mu.Unlock()
+
+	if err != nil {
+		//play will run into the same error, and deal with it there
+		return
+	}
+
+	output.Lock()
+	if d.cur != nil && d.next == nil {
+		d.next = t
+	}
+	output.Unlock()
+}
+
+//unprime takes back the song lined up to play next, even if it's already fading in. Returns its
+//file name, or "" when nothing was lined up.
+func (d *deck) unprime() string {
+	d.priming.Wait()
+
+	d.mu.Lock()
+	sF, t := d.primed, d.primedTrack
+	d.primed, d.primedTrack = nil, nil
+	d.mu.Unlock()
+
+	if t == nil {
+		if sF == nil {
+			return ""
+		}
+
+		return sF.FileName
+	}
+
+	output.Lock()
+	if d.next == t {
+		d.next = nil
+	}
+
+	if d.cur == t {
+		d.cur = nil
+	}
+	output.Unlock()
+
+	_ = t.s.Close()
+	return t.name
+}
+
+//upNext returns the song to play next: the primed one if there is one, or else the next one from
+//the library.
+func (d *deck) upNext() *SongFile {
+	d.priming.Wait()
+
+	d.mu.Lock()
+	sF := d.primed
+	d.mu.Unlock()
+
+	if sF != nil {
+		return sF
+	}
+
+	return lib.nextSong()
+}
+
+//open returns a track for sF, reusing the one opened when it was primed.
+func (d *deck) open(sF *SongFile) (*track, error) {
+	d.mu.Lock()
+	primed, t := d.primed, d.primedTrack
+	d.primed, d.primedTrack = nil, nil
+	d.mu.Unlock()
+
+	if primed == sF && t != nil {
+		return t, nil
+	}
+
+	return sF.initFile()
+}
+
+//startPriming lines up the next song in the background.
+func (d *deck) startPriming() {
+	d.priming.Add(1)
+	go d.prime()
+}
+
+//resampleQuality trades cpu for fidelity when a song's sample rate doesn't match the output's.
+const resampleQuality = 4
+
+//initFile opens and decodes the song's file, resampling it to the output's rate if it needs to be.
+func (sF *SongFile) initFile() (*track, error) {
+	f, err := os.Open(sF.FileName)
+	if err != nil {
+		return nil, err
+	}
+
+	s, format, err := mp3.Decode(f)
+	if err != nil {
+		f.Close()
+		return nil, &decodeError{name: sF.FileName, err: err}
+	}
+
+	lib.mu.RLock()
+	gain := sF.gain()
+	lib.mu.RUnlock()
+
+	t := &track{name: sF.FileName, s: s, format: format, gain: gain}
+	t.buildStream()
+
+	return t, nil
+}
+
+//buildStream sets up what's played from the decoder: resampled to the output's rate if it needs to
+//be, and turned up or down to its normalized level.
+func (t *track) buildStream() {
+	t.stream = t.s
+	if t.format.SampleRate != outputRate {
+		t.stream = beep.Resample(resampleQuality, t.format.SampleRate, outputRate, t.s)
+	}
+
+	//the Gain effect multiplies by 1+Gain
+	if t.gain != 1 {
+		t.stream = &effects.Gain{Streamer: t.stream, Gain: t.gain - 1}
+	}
+}
+
+//decodeError is returned for a file that opened, but couldn't be decoded as an mp3.
+type decodeError struct {
+	name string
+	err  error
+}
+
+func (e *decodeError) Error() string {
+	return fmt.Sprintf("decoding %s: %v", e.name, e.err)
+}
+
+//relocate finds the song named name again, after a compute may have moved it within the library.
+func (sF *SongFile) relocate(name string) *SongFile {
+	lib.mu.RLock()
+	defer lib.mu.RUnlock()
+
+	if sF.FileName == name {
+		return sF
+	}
+
+	for i := range lib.Songs {
+		if lib.Songs[i].FileName == name {
+			return &lib.Songs[i]
+		}
+	}
+
+	return sF
+}
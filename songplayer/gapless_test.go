@@ -0,0 +1,345 @@
+package songplayer
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/faiface/beep"
+)
+
+//levelSong stands in for a decoded song, split into equal parts with every sample of each part at
+//the same level.
+type levelSong struct {
+	levels []float64
+	pos, n int
+	closed bool
+}
+
+func (s *levelSong) Stream(samples [][2]float64) (int, bool) {
+	if s.pos >= s.n {
+		return 0, false
+	}
+
+	k := len(samples)
+	if left := s.n - s.pos; k > left {
+		k = left
+	}
+
+	for i := range samples[:k] {
+		level := s.levels[(s.pos+i)*len(s.levels)/s.n]
+		samples[i] = [2]float64{level, level}
+	}
+
+	s.pos += k
+	return k, true
+}
+
+func (s *levelSong) Err() error       { return nil }
+func (s *levelSong) Len() int         { return s.n }
+func (s *levelSong) Position() int    { return s.pos }
+func (s *levelSong) Close() error     { s.closed = true; return nil }
+func (s *levelSong) Seek(p int) error { s.pos = p; return nil }
+
+//levelTrack is a track of n samples at level, already at the output's rate.
+func levelTrack(name string, level float64, n int) *track {
+	return levelsTrack(name, outputRate, n, level)
+}
+
+//levelsTrack is a track of n samples at rate, split evenly between levels.
+func levelsTrack(name string, rate beep.SampleRate, n int, levels ...float64) *track {
+	t := &track{
+		name:   name,
+		s:      &levelSong{levels: levels, n: n},
+		format: beep.Format{SampleRate: rate, NumChannels: 2, Precision: 2},
+		gain:   1,
+	}
+	t.buildStream()
+
+	return t
+}
+
+//awaitDone waits for the deck to report every one of names as played through, in any order,
+//ignoring songs left over from earlier tests.
+func awaitDone(t *testing.T, names ...string) {
+	t.Helper()
+
+	want := make(map[string]bool)
+	for _, name := range names {
+		want[name] = true
+	}
+
+	timeout := time.After(10 * time.Second)
+	for len(want) > 0 {
+		select {
+		case done := <-trackDone:
+			delete(want, done)
+		case <-timeout:
+			t.Fatalf("timed out waiting for %v to finish", want)
+		}
+	}
+}
+
+//run is n samples in a row at level.
+type run struct {
+	level float64
+	n     int
+}
+
+//expectLevels checks samples holds each run in turn, and nothing else.
+func expectLevels(t *testing.T, samples [][2]float64, runs ...run) {
+	t.Helper()
+
+	i := 0
+	for _, run := range runs {
+		for j := 0; j < run.n; j, i = j+1, i+1 {
+			if i >= len(samples) {
+				t.Fatalf("expected %d more samples at %v, ran out at %d", run.n-j, run.level, i)
+			}
+
+			if samples[i] != [2]float64{run.level, run.level} {
+				t.Fatalf("sample %d: expected %v, got %v", i, run.level, samples[i])
+			}
+		}
+	}
+
+	if i != len(samples) {
+		t.Fatalf("expected %d samples, got %d", i, len(samples))
+	}
+}
+
+func TestDeckSplicesIntoNextSong(t *testing.T) {
+	var d deck
+	a, b := levelTrack("a", 0.5, 30), levelTrack("b", 0.25, 100)
+	d.cur, d.next = a, b
+
+	//the first song runs out part way through the buffer, and the next one fills the rest
+	samples := make([][2]float64, 100)
+	if n, ok := d.Stream(samples); n != 100 || !ok {
+		t.Fatalf("expected the deck to fill the buffer and keep going, got %d, %v", n, ok)
+	}
+
+	expectLevels(t, samples, run{0.5, 30}, run{0.25, 70})
+	awaitDone(t, "a")
+
+	if !a.s.(*levelSong).closed {
+		t.Error("expected the finished song to be closed")
+	}
+
+	if d.cur != b || d.next != nil || b.started.IsZero() {
+		t.Fatal("expected the next song to be playing, and nothing lined up after it")
+	}
+
+	//once both have run out, the rest is silence
+	if n, ok := d.Stream(samples); n != 100 || !ok {
+		t.Fatalf("expected the deck to keep going with nothing to play, got %d, %v", n, ok)
+	}
+
+	expectLevels(t, samples, run{0.25, 30}, run{0, 70})
+	awaitDone(t, "b")
+
+	if d.cur != nil {
+		t.Fatal("expected nothing to be playing")
+	}
+}
+
+func TestDeckReportsEverySong(t *testing.T) {
+	var d deck
+
+	//nothing hears about the songs until they've all finished, as when the play loop is busy
+	samples := make([][2]float64, 100)
+	for _, name := range []string{"a", "b", "c"} {
+		d.cur = levelTrack(name, 0.5, 30)
+		d.Stream(samples)
+	}
+
+	awaitDone(t, "a", "b", "c")
+}
+
+func TestDeckStartCarriesOn(t *testing.T) {
+	useNullOutput(t)
+
+	d := deck{ctrl: &beep.Ctrl{}}
+	a, b := levelTrack("a", 0.5, 30), levelTrack("b", 0.25, 100)
+
+	//the deck already moved on to b, so starting it leaves it playing as it is
+	started := time.Now().Add(-time.Second)
+	d.cur, b.started = b, started
+
+	if err := d.start(b); err != nil {
+		t.Fatal(err)
+	}
+
+	if d.cur != b || b.started != started {
+		t.Fatal("expected the song the deck moved on to to carry on")
+	}
+
+	//starting anything else drops what the deck was holding
+	d.next = a
+	if err := d.start(a); err != nil {
+		t.Fatal(err)
+	}
+
+	if d.cur != a || d.next != nil || a.started.IsZero() {
+		t.Fatal("expected the song started to be the only one playing")
+	}
+
+	if !b.s.(*levelSong).closed || a.s.(*levelSong).closed {
+		t.Fatal("expected only the song dropped to be closed")
+	}
+}
+
+func TestDeckUnprime(t *testing.T) {
+	useNullOutput(t)
+
+	var d deck
+	if name := d.unprime(); name != "" {
+		t.Fatalf("expected nothing to take back, got %s", name)
+	}
+
+	//a song that couldn't be opened is still taken back
+	d.primed = &SongFile{FileName: "missing"}
+	if name := d.unprime(); name != "missing" || d.primed != nil {
+		t.Fatalf("expected the song that failed to open to be taken back, got %q", name)
+	}
+
+	//whether it's lined up behind the playing song, or already fading in
+	cur := levelTrack("cur", 0.5, 100)
+	for _, fadingIn := range []bool{false, true} {
+		t2 := levelTrack("next", 0.25, 100)
+		d.primed, d.primedTrack = &SongFile{FileName: "next"}, t2
+
+		d.cur, d.next = cur, t2
+		if fadingIn {
+			d.cur, d.next = t2, nil
+		}
+
+		if name := d.unprime(); name != "next" {
+			t.Fatalf("expected the primed song to be taken back, got %q", name)
+		}
+
+		if d.cur == t2 || d.next != nil || d.primed != nil || d.primedTrack != nil {
+			t.Fatalf("fading in %v: expected nothing left lined up", fadingIn)
+		}
+
+		if !t2.s.(*levelSong).closed {
+			t.Fatalf("fading in %v: expected the song taken back to be closed", fadingIn)
+		}
+	}
+}
+
+func TestDeckGaplessThroughNullSink(t *testing.T) {
+	defer useLibrary(testSongs(1))()
+
+	var mu sync.Mutex
+	var played [][2]float64
+
+	sink := newClockSink(playbackSpeed, func(sr beep.SampleRate, samples [][2]float64) {
+		mu.Lock()
+		played = append(played, samples...)
+		mu.Unlock()
+	})
+
+	prev := output
+	output = sink
+	defer func() {
+		sink.Lock()
+		close(sink.done)
+		sink.Unlock()
+
+		output = prev
+	}()
+
+	//both songs end part way through one of the sink's buffers
+	buffer := outputRate.N(outputBuffer)
+	a, b := levelTrack("a", 0.5, buffer*3/2), levelTrack("b", 0.25, buffer*5/4)
+
+	var d deck
+	d.next = b
+	if err := d.start(a); err != nil {
+		t.Fatal(err)
+	}
+
+	awaitDone(t, "a", "b")
+
+	//give the sink time to hand on the buffer b finished in
+	time.Sleep(3 * outputBuffer / playbackSpeed)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	//the sink may have pulled silence before a was started
+	lead := 0
+	for lead < len(played) && played[lead] == [2]float64{} {
+		lead++
+	}
+
+	tail := len(played) - lead - buffer*3/2 - buffer*5/4
+	if tail < 0 {
+		t.Fatalf("expected both songs to have been played, got %d samples", len(played)-lead)
+	}
+
+	expectLevels(t, played[lead:], run{0.5, buffer * 3 / 2}, run{0.25, buffer * 5 / 4}, run{0, tail})
+}
+
+func TestSeekClamps(t *testing.T) {
+	useNullOutput(t)
+
+	var d deck
+	tr := levelTrack("a", 0.5, outputRate.N(5*time.Second))
+	d.cur = tr
+
+	tests := []struct {
+		what string
+		to   func(pos time.Duration) time.Duration
+		want time.Duration
+	}{
+		{"forwards", func(pos time.Duration) time.Duration { return pos + 2*time.Second }, 2 * time.Second},
+		{"past the end", func(pos time.Duration) time.Duration { return pos + time.Minute }, 4 * time.Second},
+		{"backwards", func(pos time.Duration) time.Duration { return pos - 3*time.Second }, time.Second},
+		{"before the start", func(pos time.Duration) time.Duration { return pos - time.Minute }, 0},
+		{"to a point", func(time.Duration) time.Duration { return 3 * time.Second }, 3 * time.Second},
+	}
+
+	for _, tt := range tests {
+		d.seek(tr, tt.to)
+		if got := tr.position(); got != tt.want {
+			t.Errorf("%s: expected to be at %v, got %v", tt.what, tt.want, got)
+		}
+	}
+
+	//only the song that's playing can be moved, and not while it's fading out
+	d.cur = levelTrack("b", 0.5, 100)
+	d.seek(tr, func(time.Duration) time.Duration { return 0 })
+
+	d.cur, d.fading = tr, &fade{out: levelTrack("c", 0.5, 100)}
+	d.seek(tr, func(time.Duration) time.Duration { return 0 })
+
+	if got := tr.position(); got != 3*time.Second {
+		t.Errorf("expected the song to be left at 3s, got %v", got)
+	}
+}
+
+func TestSeekRestartsResampler(t *testing.T) {
+	useNullOutput(t)
+
+	//half the output's rate, so it's resampled, and louder in its second half
+	var d deck
+	rate := outputRate / 2
+	tr := levelsTrack("a", rate, rate.N(10*time.Second), 0.25, 0.75)
+	d.cur = tr
+
+	samples := make([][2]float64, 100)
+	tr.stream.Stream(samples)
+
+	d.seek(tr, func(time.Duration) time.Duration { return 6 * time.Second })
+	tr.stream.Stream(samples)
+
+	//the first few samples lean on the ones before the seek, which a new resampler doesn't have
+	for i := 10; i < len(samples); i++ {
+		if math.Abs(samples[i][0]-0.75) > 1e-9 {
+			t.Fatalf("sample %d: expected the song from after the seek, got %v", i, samples[i][0])
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package songplayer
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	//skipUndoWindow is how soon after a skip going back to the skipped song takes the skip back.
+	skipUndoWindow = 10 * time.Second
+
+	//maxHistory is how many of the songs heard this session can be gone back to.
+	maxHistory = 100
+)
+
+//skipRecord is how a skipped song stood before the skip, kept for a moment in case the skip was a mistake.
+type skipRecord struct {
+	name   string
+	at     time.Time
+	before PlayInfo
+}
+
+//recordHistory notes that sF has been heard, before it's updated with the play or skip. Callers must
+//hold lib.mu.
+func (lib *SongLibrary) recordHistory(sF *SongFile, skipped bool) {
+	lib.history = append(lib.history, sF.FileName)
+	if len(lib.history) > maxHistory {
+		lib.history = lib.history[len(lib.history)-maxHistory:]
+	}
+
+	lib.lastSkip = nil
+	if !skipped {
+		return
+	}
+
+	before := sF.PlayInfo
+	before.TimeContext = sF.TimeContext.clone()
+
+	lib.lastSkip = &skipRecord{
+		name:   sF.FileName,
+		at:     now(),
+		before: before,
+	}
+}
+
+//canRewind reports whether any song has been heard this session to go back to.
+func (lib *SongLibrary) canRewind() bool {
+	lib.mu.RLock()
+	defer lib.mu.RUnlock()
+
+	return len(lib.history) > 0
+}
+
+//rewind queues the last song heard to play again, followed by current and primed, which were
+//playing and lined up to play next. primed may be empty. Going back to a song skipped moments ago
+//takes the skip back, along with its penalty.
+func (lib *SongLibrary) rewind(current, primed string) {
+	lib.mu.Lock()
+	defer lib.mu.Unlock()
+
+	if len(lib.history) == 0 {
+		return
+	}
+
+	prev := lib.history[len(lib.history)-1]
+	lib.history = lib.history[:len(lib.history)-1]
+
+	if s := lib.lastSkip; s != nil && s.name == prev && now().Sub(s.at) <= skipUndoWindow {
+		lib.undoSkip(s)
+	}
+
+	lib.lastSkip = nil
+
+	requeue := []string{prev, current}
+	if primed != "" {
+		requeue = append(requeue, primed)
+	}
+
+	lib.Queue = append(requeue, lib.Queue...)
+}
+
+//undoSkip takes back the skip s recorded: the song's skip counts and times go back the way they
+//were, along with the library's skip count and any penalty a compute has charged for the skip since.
+//Anything else changed in the meantime, like a rating or a ban, is kept. Callers must hold lib.mu.
+func (lib *SongLibrary) undoSkip(s *skipRecord) {
+	var sF *SongFile
+	for i := range lib.Songs {
+		if lib.Songs[i].FileName == s.name {
+			sF = &lib.Songs[i]
+			break
+		}
+	}
+
+	//skips in the outro count as plays, which leaves nothing to take back
+	if sF == nil || sF.TotalSkips <= s.before.TotalSkips {
+		return
+	}
+
+	if b := sF.Breakdown; b.Computed >= s.at.Unix() && b.SkipPenalty > 0 {
+		sF.Score += b.SkipPenalty
+		sF.SkipDebt = math.Max(0, sF.SkipDebt-b.SkipPenalty)
+	}
+
+	sF.TotalSkips = s.before.TotalSkips
+	sF.ConsecutiveSkips = s.before.ConsecutiveSkips
+	sF.LastSkipped = s.before.LastSkipped
+	sF.LastSkipPosition = s.before.LastSkipPosition
+	sF.HourSkips = s.before.HourSkips
+	sF.DaySkips = s.before.DaySkips
+
+	if lib.NumSkips > 0 {
+		lib.NumSkips--
+	}
+}
+
+//clone copies the tallies, so recording into one copy leaves the other alone.
+func (tc TimeContext) clone() TimeContext {
+	cp := func(s []uint32) []uint32 {
+		if s == nil {
+			return nil
+		}
+
+		return append([]uint32(nil), s...)
+	}
+
+	return TimeContext{
+		HourPlays: cp(tc.HourPlays),
+		HourSkips: cp(tc.HourSkips),
+		DayPlays:  cp(tc.DayPlays),
+		DaySkips:  cp(tc.DaySkips),
+	}
+}
@@ -0,0 +1,109 @@
+package songplayer
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+//skipFirst skips the first song of the library at the given position, as the player does.
+func skipFirst(at time.Duration) *SongFile {
+	sF := &lib.Songs[0]
+
+	lib.mu.Lock()
+	lib.recordHistory(sF, true)
+	sF.update(now().Add(-at), true, at)
+	lib.mu.Unlock()
+
+	return sF
+}
+
+func TestUndoSkipKeepsPreferences(t *testing.T) {
+	defer useLibrary(testSongs(3))()
+
+	at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return at }
+
+	sF := skipFirst(30 * time.Second)
+	if sF.TotalSkips != 1 || lib.NumSkips != 1 {
+		t.Fatalf("expected the skip to be counted, got %d skips", sF.TotalSkips)
+	}
+
+	//opinions given while the skip can still be taken back stay put
+	sF.Rating = 5
+	sF.Favourite = true
+	sF.Banned = true
+
+	at = at.Add(5 * time.Second)
+	lib.rewind(lib.Songs[1].FileName, "")
+
+	want := PlayInfo{Rating: 5, Favourite: true, Banned: true, TimeContext: TimeContext{}}
+	if !reflect.DeepEqual(sF.PlayInfo, want) {
+		t.Fatalf("expected only the skip to be taken back\n%+v\ngot\n%+v", want, sF.PlayInfo)
+	}
+
+	if lib.NumSkips != 0 {
+		t.Fatalf("expected the library's skip count to be taken back, got %d", lib.NumSkips)
+	}
+
+	if want := []string{sF.FileName, lib.Songs[1].FileName}; !reflect.DeepEqual(lib.Queue, want) {
+		t.Fatalf("expected %v to be queued, got %v", want, lib.Queue)
+	}
+}
+
+func TestUndoSkipTakesBackPenalty(t *testing.T) {
+	songs := testSongs(3)
+	for i := range songs {
+		songs[i].Score = 50
+	}
+
+	defer useLibrary(songs)()
+
+	at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return at }
+
+	name := skipFirst(30 * time.Second).FileName
+
+	//a compute straight after the skip charges its penalty
+	lib.mu.Lock()
+	lib.seedCompute()
+	lib.compute()
+	lib.mu.Unlock()
+
+	sF := lib.findSong(name)
+	penalty, score := sF.Breakdown.SkipPenalty, sF.Score
+	if penalty == 0 {
+		t.Fatal("expected the compute to charge a skip penalty")
+	}
+
+	lib.rewind(lib.Songs[1].FileName, "")
+
+	if sF.Score != score+penalty || sF.SkipDebt != 0 || sF.TotalSkips != 0 || sF.ConsecutiveSkips != 0 {
+		t.Fatalf("expected the penalty of %v to be taken back, got a score of %v from %v, debt of %v and %d skips",
+			penalty, sF.Score, score, sF.SkipDebt, sF.TotalSkips)
+	}
+}
+
+func TestUndoSkipLeavesPlays(t *testing.T) {
+	defer useLibrary(testSongs(3))()
+
+	at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return at }
+
+	//a skip in the outro counts as a play, which going back doesn't take away
+	sF := skipFirst(lib.Songs[0].PlayTime - 5*time.Second)
+	lib.rewind(lib.Songs[1].FileName, "")
+
+	if sF.TotalPlays != 1 || sF.LastPlayed != at.Unix() {
+		t.Fatalf("expected the play to stand, got %d plays", sF.TotalPlays)
+	}
+
+	//and skips taken back too late stay skips
+	sF = skipFirst(30 * time.Second)
+	at = at.Add(skipUndoWindow + time.Second)
+	lib.rewind(lib.Songs[1].FileName, "")
+
+	if sF.TotalSkips != 1 {
+		t.Fatalf("expected a skip outside the undo window to stand, got %d skips", sF.TotalSkips)
+	}
+}
@@ -18,8 +18,16 @@ type (
 		TotalTime time.Duration `json:"total_time,omitempty"`
 		Pruned    bool          `json:"pruned,omitempty"`
 		NextSong  int           `json:"next_song,omitempty"`
+		BatchSize int           `json:"batch_size,omitempty"`
+		Queue     []string      `json:"queue,omitempty"`
+		Version   int           `json:"cache_version,omitempty"`
+		Volume    int           `json:"volume"`          //Volume is the playback volume, as a percentage
+		Muted     bool          `json:"muted,omitempty"` //Muted silences playback without losing the volume
 		lbWg      sync.WaitGroup
 		mu        sync.RWMutex
+		rng       *rand.Rand
+		history   []string    //history holds the file names of the songs heard this session, oldest first
+		lastSkip  *skipRecord //lastSkip is the latest song skipped, while it can still be taken back
 		LibInfo
 	}
 
@@ -27,12 +35,14 @@ type (
 	LibInfo struct {
 		AvgPlays    uint64 `json:"avg_plays,omitempty"`
 		AvgSkips    float64 `json:"avg_skips,omitempty"`
-		AvgScore    uint64  `json:"avg_score,omitempty"`
+		AvgScore    float64 `json:"avg_score,omitempty"`
 		LastCompute int64   `json:"last_compute_time,omitempty"`
+		CycleStart  int64   `json:"coverage_cycle_start,omitempty"` //CycleStart is when the current coverage cycle began
+		Seed        int64   `json:"seed,omitempty"`
 
 		NumSkips   uint64        `json:"total_skips,omitempty"`
 		NumPlays   uint64        `json:"total_plays,omitempty"`
-		TotalScore uint64        `json:"total_score,omitempty"`
+		TotalScore float64       `json:"total_score,omitempty"`
 		TimePlayed time.Duration `json:"total_time_played,omitempty"`
 	}
 )
@@ -40,6 +50,9 @@ type (
 var libDir = ""
 var maxSize = 25
 
+//now is the clock scoring runs against, swapped out when replaying a compute.
+var now = time.Now
+
 //SetPlaylistMaxSize indicates to the player at what interval of played songs it should initiate computes.
 // if unspecified, the maxSize defaults to 25 songs
 func SetPlaylistMaxSize(max int) {
@@ -69,6 +82,16 @@ func (lib *SongLibrary) NextSongFiles(num int) (s []SongFile) {
 	return append(s, lib.Songs[lib.NextSong-1:lib.NextSong+num-1]...)
 }
 
+//Batch returns the songs the most recent compute lined up to be played, in order.
+func (lib *SongLibrary) Batch() []SongFile {
+	n := lib.BatchSize
+	if n > len(lib.Songs) {
+		n = len(lib.Songs)
+	}
+
+	return lib.Songs[:n]
+}
+
 //Play begins the cycle of playing songs
 func (lib *SongLibrary) BeginPlaying() {
 	numSongs := len(lib.Songs)
@@ -83,25 +106,103 @@ func (lib *SongLibrary) BeginPlaying() {
 		maxSize = int(math.Floor(0.01*float64(len(lib.Songs)))) + 1
 	}
 
+	go lib.measureMissing()
+
 	fmt.Println("beginning to play songs.")
-	for !lib.Songs[lib.NextSong].play() {
-		if lib.NextSong >= maxSize {
-			fmt.Println("server computing scores")
-			lib.computeScores()
+	for {
+		if player.upNext().play() {
+			return
 		}
 
-		lib.NextSong++
 		fmt.Println("server persisting self")
 		lib.persistSelf()
 	}
 }
 
+//nextSong moves on to the next queued song, or failing that, the next song of the batch, computing
+//a new batch once this one runs out. When no song can be played, the player idles until one can.
+//The simulator has no one to unban songs or bring files back, so gets nil instead.
+func (lib *SongLibrary) nextSong() *SongFile {
+	if sF := lib.popQueue(); sF != nil {
+		return sF
+	}
+
+	idle := false
+
+	for {
+		sF, ok := lib.takeFromBatch()
+		if sF != nil {
+			return sF
+		}
+
+		if ok {
+			continue
+		}
+
+		if !simulating {
+			fmt.Println("server computing scores")
+		}
+		lib.computeScores()
+
+		lib.mu.RLock()
+		empty := lib.BatchSize == 0
+		lib.mu.RUnlock()
+
+		if !empty {
+			continue
+		}
+
+		if simulating {
+			return nil
+		}
+
+		if !idle {
+			idle = true
+			if lib.anyUnavailable() {
+				fmt.Println("no songs can be played until their files are back, waiting")
+			} else {
+				fmt.Println("every song is banned or excluded from play, waiting until one is allowed again")
+			}
+		}
+
+		time.Sleep(idleRetry)
+
+		//a song may have been queued while we waited
+		if sF := lib.popQueue(); sF != nil {
+			return sF
+		}
+	}
+}
+
+//takeFromBatch moves on past the next song of the batch, returning it, or nil when it can no longer
+//be played. ok is false once the batch has run out. The next song is picked on the prime goroutine,
+//while the ui and socket read the batch, so it's all done under lib.mu.
+func (lib *SongLibrary) takeFromBatch() (sF *SongFile, ok bool) {
+	lib.mu.Lock()
+	defer lib.mu.Unlock()
+
+	if lib.NextSong >= lib.BatchSize {
+		return nil, false
+	}
+
+	sF = &lib.Songs[lib.NextSong]
+	lib.NextSong++
+
+	//Songs can be banned, lose their files or fail to decode after the batch they're in was computed
+	if sF.Banned || sF.Unavailable || sF.Undecodable != 0 {
+		return nil, true
+	}
+
+	return sF, true
+}
+
 //LoadFromFiles initiates recursive directory scanning to find mp3 files.
 func (lib *SongLibrary) LoadFromFiles() {
 	lib.lbWg.Add(1)
 	getSongs(libDir)
 
 	lib.lbWg.Wait()
+	lib.measureAlbums()
 }
 
 func getSongs(dir string) {
@@ -154,6 +255,10 @@ func getSongs(dir string) {
 				continue
 			}
 
+			//plenty of files have no tags, those fall back to their directories. Songs without
+			//ReplayGain tags are measured once playback starts, one at a time.
+			_ = song.loadTags()
+
 			songs = append(songs, song)
 			continue
 		}
@@ -169,19 +274,39 @@ func getSongs(dir string) {
 //Utilities for sorting the library of songs
 type byScore []SongFile
 
-func (b byScore) Len() int           { return len(b) }
-func (b byScore) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
-func (b byScore) Less(i, j int) bool { return b[i].Score < b[j].Score }
+func (b byScore) Len() int      { return len(b) }
+func (b byScore) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byScore) Less(i, j int) bool {
+	//songs that can't be played sink to the bottom, whatever their score.
+	if b[i].eligible != b[j].eligible {
+		return !b[i].eligible
+	}
+
+	return b[i].pick < b[j].pick
+}
 
 func (lib *SongLibrary) computeScores() {
 	lib.mu.Lock()
 	defer lib.mu.Unlock()
 
 	// Don't actually compute if we're just loading from a file
-	if lib.NumPlays > 0 && lib.NextSong < maxSize {
+	if lib.NumPlays > 0 && lib.NextSong < lib.BatchSize {
 		return
 	}
 
+	//simulated songs have no files to look for
+	if !simulating {
+		lib.checkAvailability()
+	}
+	lib.seedCompute()
+	lib.compute()
+}
+
+//compute scores and orders every song in the library. Callers must hold lib.mu and have seeded lib.rng.
+func (lib *SongLibrary) compute() {
+	//Avg Score will lag behind, as it's taken from the previous compute's total.
+	lib.AvgScore = lib.TotalScore / float64(len(lib.Songs))
+
 	lib.TotalTime = 0
 	lib.NumPlays = 0
 	lib.NumSkips = 0
@@ -200,23 +325,106 @@ func (lib *SongLibrary) computeScores() {
 
 	lib.AvgPlays = uint64(float64(lib.NumPlays) / float64(len(lib.Songs)))
 	lib.AvgSkips = float64(lib.NumSkips) / float64(len(lib.Songs))
-	lib.AvgScore = lib.TotalScore / uint64(len(lib.Songs))
 
-	//Avg Score will lag behind, but at least with a second pass, we'll have an average.
+	numEligible := 0
+
 	for i := 0; i < len(lib.Songs); i++ {
-		lib.Songs[i].computeScore()
+		sF := &lib.Songs[i]
+
+		sF.computeScore()
 		// we only care about the scores of songs that are positive.
-		if lib.Songs[i].Score > 0 {
-			lib.TotalScore += lib.Songs[i].Score
+		if sF.Score > 0 {
+			lib.TotalScore += sF.Score
 		}
+
+		b := &sF.Breakdown
+		b.Preference = sF.preference()
+		b.TimeContext = sF.timeContextScore(now())
+		b.Multiplier = sF.multiplier(now())
+		sF.rank = scaleRank(sF.Score+b.Preference+b.TimeContext, b.Multiplier)
+		b.Rank = sF.rank
+
+		b.Excluded = lib.exclusion(sF)
+		if sF.eligible = b.Excluded == ""; sF.eligible {
+			numEligible++
+		}
+	}
+
+	if albumMode {
+		numEligible += lib.admitAlbums()
+	}
+
+	if numEligible == 0 {
+		numEligible = lib.ignorePlaylist()
 	}
 
+	if numEligible == 0 {
+		numEligible = lib.admitPlaying()
+	}
 
+	numEligible -= lib.excludeCovered()
+	numEligible -= lib.excludeRecent(numEligible)
 
-	lib.LastCompute = time.Now().Unix()
+	lib.LastCompute = now().Unix()
 	lib.NextSong = 0
+
+	if albumMode {
+		lib.BatchSize = lib.orderByAlbum()
+		return
+	}
+
+	lib.BatchSize = maxSize
+	if lib.BatchSize > numEligible {
+		lib.BatchSize = numEligible
+	}
+
+	lib.drawPicks()
+
 	//O(n*log(n))
 	sort.Sort(sort.Reverse(byScore(lib.Songs)))
+	lib.promoteDiscoveries(lib.BatchSize, numEligible)
+	lib.spreadBatch(lib.BatchSize, numEligible)
+}
+
+//exclusion gives the reason a song can't be picked for the next batch at all, or "" when it can.
+func (lib *SongLibrary) exclusion(sF *SongFile) string {
+	if sF.Banned {
+		return "never play"
+	}
+
+	if sF.Unavailable {
+		return "file missing"
+	}
+
+	if sF.Undecodable != 0 {
+		return "can't be decoded"
+	}
+
+	if activePlaylist != nil && !activePlaylist.Match(sF) {
+		return "not in the playlist"
+	}
+
+	//the next song is picked before the playing one finishes, so it hasn't been counted as heard yet
+	if playing, _ := nowPlaying.Load().(string); sF.FileName == playing {
+		return "playing now"
+	}
+
+	return ""
+}
+
+//admitPlaying lets the song that's playing into the batch, when it's the only one that could be
+//picked. Returns how many songs it let in.
+func (lib *SongLibrary) admitPlaying() int {
+	for i := range lib.Songs {
+		sF := &lib.Songs[i]
+		if sF.Breakdown.Excluded == "playing now" {
+			sF.Breakdown.Excluded = ""
+			sF.eligible = true
+			return 1
+		}
+	}
+
+	return 0
 }
 
 //Currently unused function, explicitly for
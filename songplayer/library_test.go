@@ -0,0 +1,106 @@
+package songplayer
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+//testSongs builds n songs, ten to an album and four albums to an artist, none of which have files.
+func testSongs(n int) []SongFile {
+	songs := make([]SongFile, n)
+	for i := range songs {
+		artist, album := i/40, i/10
+		songs[i] = SongFile{
+			FileName: fmt.Sprintf("/test/artist%d/album%d/track%d.mp3", artist, album, i%10+1),
+			PlayTime: 3 * time.Minute,
+			Tags: Tags{
+				Artist: fmt.Sprintf("artist%d", artist),
+				Album:  fmt.Sprintf("album%d", album),
+				Track:  i%10 + 1,
+			},
+		}
+	}
+
+	return songs
+}
+
+//useLibrary swaps in a library of songs for the length of a test, returning a func that puts the
+//old one back. Computes run as they do in the simulator, so the songs don't need files.
+func useLibrary(songs []SongFile) func() {
+	prevLib, prevSim, prevNow, prevMax := lib, simulating, now, maxSize
+
+	lib = &SongLibrary{Songs: songs, Volume: 100}
+	simulating = true
+	SetScoreWeights(DefaultScoreWeights)
+	SetSeed(1)
+
+	return func() {
+		lib, simulating, now, maxSize = prevLib, prevSim, prevNow, prevMax
+	}
+}
+
+func TestEveryBannedSongDoesNotPanic(t *testing.T) {
+	defer useLibrary(testSongs(5))()
+
+	for i := range lib.Songs {
+		lib.Songs[i].Banned = true
+	}
+
+	if sF := lib.nextSong(); sF != nil {
+		t.Fatalf("expected nothing to play with every song banned, got %s", sF.FileName)
+	}
+}
+
+func TestOnlySongPlaysAgain(t *testing.T) {
+	defer useLibrary(testSongs(1))()
+	defer nowPlaying.Store("")
+
+	//with nothing else to pick, the song that's playing is picked again rather than none at all
+	nowPlaying.Store(lib.Songs[0].FileName)
+
+	if sF := lib.nextSong(); sF == nil || sF.FileName != lib.Songs[0].FileName {
+		t.Fatalf("expected the only song to be picked while it plays, got %v", sF)
+	}
+}
+
+func TestNextSongWhileExplaining(t *testing.T) {
+	songs := testSongs(20)
+	defer useLibrary(songs)()
+	SetPlaylistMaxSize(3)
+
+	name := songs[0].FileName
+
+	//songs are picked on the prime goroutine while the socket explains them, which the race
+	//detector keeps an eye on
+	stop := make(chan struct{})
+	started, explained := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(explained)
+
+		close(started)
+
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			if _, err := lib.Explain(name); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	<-started
+	for i := 0; i < 50; i++ {
+		if sF := lib.nextSong(); sF == nil {
+			t.Fatal("expected a song to play")
+		}
+	}
+
+	close(stop)
+	<-explained
+}
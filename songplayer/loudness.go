@@ -0,0 +1,417 @@
+package songplayer
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/faiface/beep/mp3"
+)
+
+//rgReference is the loudness ReplayGain 2 gains are measured against, in LUFS.
+const rgReference = -18
+
+//Loudness is how loud a song is, either measured from its audio or read from its ReplayGain tags.
+type Loudness struct {
+	Track     float64 `json:"track_lufs"`           //Track is the song's integrated loudness, in LUFS
+	TrackPeak float64 `json:"track_peak"`           //TrackPeak is the song's loudest sample, 1 being full scale
+	Album     float64 `json:"album_lufs,omitempty"` //Album is the loudness of the song's whole album, 0 when unknown
+	AlbumPeak float64 `json:"album_peak,omitempty"`
+	Tagged    bool    `json:"tagged,omitempty"` //Tagged is set when the values came from ReplayGain tags
+	Silent    bool    `json:"silent,omitempty"` //Silent is set when nothing in the song was loud enough to measure
+}
+
+//Normalization evens out the volume between songs mastered at different levels.
+type Normalization struct {
+	//Mode is off, track to bring every song to the target, or album to move whole albums together,
+	//keeping the differences between their tracks.
+	Mode string `json:"mode"`
+
+	//Target is the loudness songs are brought to, in LUFS. -18 when 0, matching ReplayGain.
+	Target float64 `json:"target_lufs,omitempty"`
+
+	//AllowClipping lets a song be turned up past the point its loudest sample clips.
+	AllowClipping bool `json:"allow_clipping,omitempty"`
+}
+
+var normalization Normalization
+
+//SetNormalization configures the gain songs are played at.
+func SetNormalization(n Normalization) error {
+	switch n.Mode {
+	case "":
+		n.Mode = "off"
+	case "off", "track", "album":
+	default:
+		return fmt.Errorf("normalization mode must be off, track or album. got: %s", n.Mode)
+	}
+
+	if n.Target == 0 {
+		n.Target = rgReference
+	}
+
+	if n.Target > 0 {
+		return fmt.Errorf("the normalization target is in LUFS, so can't be above 0. got: %v", n.Target)
+	}
+
+	normalization = n
+	return nil
+}
+
+//gain is how much to scale the song's samples by to bring it to the target loudness. Songs that
+//haven't been measured play as they are.
+func (sF *SongFile) gain() float64 {
+	l := sF.Loudness
+	if normalization.Mode == "off" || normalization.Mode == "" || l == nil || l.Silent {
+		return 1
+	}
+
+	lufs, peak := l.Track, l.TrackPeak
+	if normalization.Mode == "album" && l.Album != 0 {
+		lufs, peak = l.Album, l.AlbumPeak
+	}
+
+	g := math.Pow(10, (normalization.Target-lufs)/20)
+
+	if !normalization.AllowClipping && peak > 0 && g*peak > 1 {
+		g = 1 / peak
+	}
+
+	return g
+}
+
+//readReplayGain fills in the song's loudness from its ReplayGain tags, if it has a track gain.
+func (sF *SongFile) readReplayGain(frames map[string]string) {
+	trackGain, ok := parseGain(frames["TXXX:REPLAYGAIN_TRACK_GAIN"])
+	if !ok {
+		return
+	}
+
+	l := &Loudness{Track: rgReference - trackGain, Tagged: true}
+	l.TrackPeak, _ = parseGain(frames["TXXX:REPLAYGAIN_TRACK_PEAK"])
+
+	if albumGain, ok := parseGain(frames["TXXX:REPLAYGAIN_ALBUM_GAIN"]); ok {
+		l.Album = rgReference - albumGain
+		l.AlbumPeak, _ = parseGain(frames["TXXX:REPLAYGAIN_ALBUM_PEAK"])
+	}
+
+	sF.Loudness = l
+}
+
+//parseGain reads a ReplayGain value, which is a number optionally followed by its unit, like "-6.5 dB".
+func parseGain(s string) (float64, bool) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimSpace(strings.TrimSuffix(strings.TrimSuffix(s, "dB"), "db"))
+
+	v, err := strconv.ParseFloat(s, 64)
+	return v, err == nil
+}
+
+//measureLoudness decodes the whole song and measures its loudness as laid out in ITU-R BS.1770,
+//which EBU R128 and ReplayGain 2 both build on.
+func (sF *SongFile) measureLoudness() error {
+	f, err := os.Open(sF.FileName)
+	if err != nil {
+		return err
+	}
+
+	s, format, err := mp3.Decode(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	defer s.Close()
+
+	m := newLoudnessMeter(float64(format.SampleRate), format.NumChannels)
+	samples := make([][2]float64, 4096)
+
+	for {
+		n, ok := s.Stream(samples)
+		m.write(samples[:n])
+
+		if !ok {
+			break
+		}
+	}
+
+	if err = s.Err(); err != nil {
+		return err
+	}
+
+	lufs, ok := m.integrated()
+	sF.Loudness = &Loudness{Track: lufs, TrackPeak: m.peak, Silent: !ok}
+	return nil
+}
+
+//measureAlbums works out the loudness of every album whose tags didn't already carry it, from the
+//loudness of its tracks weighted by their length. Albums with tracks that haven't been measured yet
+//are left until they have. Callers must hold lib.mu, or have the library to themselves.
+func (lib *SongLibrary) measureAlbums() {
+	type album struct {
+		songs          []*SongFile
+		power, seconds float64
+		peak           float64
+		incomplete     bool
+	}
+
+	albums := make(map[string]*album)
+
+	for i := range lib.Songs {
+		sF := &lib.Songs[i]
+
+		a, ok := albums[sF.Album()]
+		if !ok {
+			a = &album{}
+			albums[sF.Album()] = a
+		}
+
+		a.songs = append(a.songs, sF)
+
+		l := sF.Loudness
+		if l == nil || (l.Tagged && l.Album != 0) {
+			a.incomplete = true
+			continue
+		}
+
+		//a silent track adds nothing to how loud the album is
+		if l.Silent {
+			continue
+		}
+
+		secs := sF.PlayTime.Seconds()
+		a.power += secs * math.Pow(10, l.Track/10)
+		a.seconds += secs
+		a.peak = math.Max(a.peak, l.TrackPeak)
+	}
+
+	for _, a := range albums {
+		if a.incomplete || a.seconds == 0 {
+			continue
+		}
+
+		lufs := 10 * math.Log10(a.power/a.seconds)
+		for _, sF := range a.songs {
+			if !sF.Loudness.Silent {
+				sF.Loudness.Album, sF.Loudness.AlbumPeak = lufs, a.peak
+			}
+		}
+	}
+}
+
+//measureIfNeeded measures a song that's about to be lined up without a loudness, so normalizing it
+//doesn't have to wait until the next scan. It decodes the whole song, so it's only ever called off
+//the path playback waits on.
+func (sF *SongFile) measureIfNeeded() {
+	if normalization.Mode == "off" || normalization.Mode == "" {
+		return
+	}
+
+	lib.mu.RLock()
+	measured := sF.Loudness != nil
+	lib.mu.RUnlock()
+
+	if measured {
+		return
+	}
+
+	m := SongFile{FileName: sF.FileName}
+	if err := m.measureLoudness(); err != nil {
+		fmt.Printf("unable to measure the loudness of %s: %v\n", sF.FileName, err)
+		return
+	}
+
+	lib.mu.Lock()
+	sF.Loudness = m.Loudness
+	lib.mu.Unlock()
+}
+
+//measureMissing measures every song that doesn't have a loudness yet, one at a time, in the
+//background. Songs that weren't tagged when the library was scanned, or that come from caches older
+//than loudness measurement, would otherwise play unnormalized until they happen to be primed.
+func (lib *SongLibrary) measureMissing() {
+	if normalization.Mode == "off" || normalization.Mode == "" {
+		return
+	}
+
+	lib.mu.RLock()
+	var missing []string
+	for i := range lib.Songs {
+		if sF := &lib.Songs[i]; sF.Loudness == nil && !sF.Unavailable && sF.Undecodable == 0 {
+			missing = append(missing, sF.FileName)
+		}
+	}
+	lib.mu.RUnlock()
+
+	if len(missing) == 0 {
+		return
+	}
+
+	fmt.Printf("measuring the loudness of %d songs in the background\n", len(missing))
+
+	for _, name := range missing {
+		m := SongFile{FileName: name}
+		if err := m.measureLoudness(); err != nil {
+			continue
+		}
+
+		//a compute may have moved the song since it was listed
+		lib.mu.Lock()
+		if sF := lib.findSong(name); sF != nil && sF.Loudness == nil {
+			sF.Loudness = m.Loudness
+		}
+		lib.mu.Unlock()
+	}
+
+	lib.mu.Lock()
+	lib.measureAlbums()
+	lib.mu.Unlock()
+
+	fmt.Println("finished measuring loudness")
+}
+
+//loudnessMeter measures integrated loudness. Samples are K-weighted, then their power is gated over
+//400ms blocks overlapping by 75%, so silence and quiet passages don't drag the result down.
+type loudnessMeter struct {
+	filters  [2][2]biquad //the two K-weighting stages, for each channel
+	channels int          //channels is how many channels the song really has. Mono is decoded onto both
+
+	//subBlocks holds the power of each 100ms, four of which make up a block
+	subBlock  int
+	pos       int
+	sum       float64
+	subBlocks []float64
+
+	peak float64
+}
+
+func newLoudnessMeter(rate float64, channels int) *loudnessMeter {
+	shelf, highPass := kWeighting(rate)
+
+	if channels < 1 || channels > 2 {
+		channels = 2
+	}
+
+	m := &loudnessMeter{subBlock: int(rate / 10), channels: channels}
+	for c := range m.filters {
+		m.filters[c] = [2]biquad{shelf, highPass}
+	}
+
+	return m
+}
+
+func (m *loudnessMeter) write(samples [][2]float64) {
+	for _, s := range samples {
+		for c, v := range s[:m.channels] {
+			m.peak = math.Max(m.peak, math.Abs(v))
+
+			v = m.filters[c][0].process(v)
+			v = m.filters[c][1].process(v)
+			m.sum += v * v
+		}
+
+		m.pos++
+		if m.pos == m.subBlock {
+			m.subBlocks = append(m.subBlocks, m.sum/float64(m.subBlock))
+			m.pos, m.sum = 0, 0
+		}
+	}
+}
+
+//integrated is the gated loudness of everything written so far, in LUFS. It's false when nothing
+//was loud enough to get past the gates, such as for silence.
+func (m *loudnessMeter) integrated() (float64, bool) {
+	var blocks []float64
+	for i := 3; i < len(m.subBlocks); i++ {
+		z := (m.subBlocks[i-3] + m.subBlocks[i-2] + m.subBlocks[i-1] + m.subBlocks[i]) / 4
+
+		//the absolute gate drops blocks quieter than -70 LUFS
+		if blockLoudness(z) > -70 {
+			blocks = append(blocks, z)
+		}
+	}
+
+	if len(blocks) == 0 {
+		return 0, false
+	}
+
+	//the relative gate drops blocks more than 10 LU below the loudness of those left
+	relative := blockLoudness(mean(blocks, math.Inf(-1))) - 10
+	return blockLoudness(mean(blocks, relative)), true
+}
+
+//mean is the average power of the blocks louder than gate.
+func mean(blocks []float64, gate float64) float64 {
+	var sum float64
+	var n int
+
+	for _, z := range blocks {
+		if blockLoudness(z) > gate {
+			sum += z
+			n++
+		}
+	}
+
+	if n == 0 {
+		return 0
+	}
+
+	return sum / float64(n)
+}
+
+func blockLoudness(z float64) float64 {
+	return -0.691 + 10*math.Log10(z)
+}
+
+//biquad is a second order IIR filter.
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	x1, x2, y1, y2     float64
+}
+
+func (f *biquad) process(x float64) float64 {
+	y := f.b0*x + f.b1*f.x1 + f.b2*f.x2 - f.a1*f.y1 - f.a2*f.y2
+	f.x1, f.x2 = x, f.x1
+	f.y1, f.y2 = y, f.y1
+	return y
+}
+
+//kWeighting returns BS.1770's high shelf and high pass filters, designed for the given sample rate
+//rather than taken from the standard's 48kHz coefficients.
+func kWeighting(rate float64) (biquad, biquad) {
+	const (
+		shelfFreq = 1681.974450955533
+		shelfGain = 3.999843853973347
+		shelfQ    = 0.7071752369554196
+		passFreq  = 38.13547087602444
+		passQ     = 0.5003270373238773
+	)
+
+	k := math.Tan(math.Pi * shelfFreq / rate)
+	vh := math.Pow(10, shelfGain/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/shelfQ + k*k
+
+	shelf := biquad{
+		b0: (vh + vb*k/shelfQ + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/shelfQ + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/shelfQ + k*k) / a0,
+	}
+
+	k = math.Tan(math.Pi * passFreq / rate)
+	a0 = 1 + k/passQ + k*k
+
+	highPass := biquad{
+		b0: 1,
+		b1: -2,
+		b2: 1,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/passQ + k*k) / a0,
+	}
+
+	return shelf, highPass
+}
@@ -0,0 +1,298 @@
+package songplayer
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+//writeSine feeds the meter secs seconds of a sine wave at freq Hz, peaking at dbfs.
+func writeSine(m *loudnessMeter, rate, freq, dbfs, secs float64) {
+	amp := math.Pow(10, dbfs/20)
+
+	samples := make([][2]float64, int(rate*secs))
+	for i := range samples {
+		v := amp * math.Sin(2*math.Pi*freq*float64(i)/rate)
+		samples[i] = [2]float64{v, v}
+	}
+
+	m.write(samples)
+}
+
+func expectLoudness(t *testing.T, name string, m *loudnessMeter, want float64) {
+	t.Helper()
+
+	got, ok := m.integrated()
+	if !ok {
+		t.Fatalf("%s: expected a measurement", name)
+	}
+
+	if math.Abs(got-want) > 0.1 {
+		t.Errorf("%s: expected %.2f LUFS, got %.2f", name, want, got)
+	}
+}
+
+func TestLoudnessOfSine(t *testing.T) {
+	for _, rate := range []float64{44100, 48000} {
+		//a mono 997Hz sine at -20dBFS is the reference for -23 LUFS, once K-weighting and the
+		//sine's rms are accounted for
+		m := newLoudnessMeter(rate, 1)
+		writeSine(m, rate, 997, -20, 20)
+		expectLoudness(t, "mono", m, -23)
+
+		if math.Abs(m.peak-0.1) > 1e-3 {
+			t.Errorf("expected a peak of 0.1, got %v", m.peak)
+		}
+
+		//the same sine in both channels of a stereo song is twice the power
+		m = newLoudnessMeter(rate, 2)
+		writeSine(m, rate, 997, -20, 20)
+		expectLoudness(t, "stereo", m, -20)
+
+		m = newLoudnessMeter(rate, 2)
+		writeSine(m, rate, 997, -23, 20)
+		expectLoudness(t, "stereo at -23dBFS", m, -23)
+	}
+}
+
+func TestLoudnessGating(t *testing.T) {
+	const rate = 48000
+
+	//silence is dropped by the absolute gate
+	m := newLoudnessMeter(rate, 1)
+	writeSine(m, rate, 997, -20, 20)
+	writeSine(m, rate, 997, -200, 20)
+	expectLoudness(t, "tone then silence", m, -23)
+
+	//a passage 20dB down is dropped by the relative gate
+	m = newLoudnessMeter(rate, 1)
+	writeSine(m, rate, 997, -20, 20)
+	writeSine(m, rate, 997, -40, 20)
+	expectLoudness(t, "tone then quiet tone", m, -23)
+
+	//but one only 6dB down still counts
+	m = newLoudnessMeter(rate, 1)
+	writeSine(m, rate, 997, -20, 20)
+	writeSine(m, rate, 997, -26, 20)
+	expectLoudness(t, "tone then slightly quieter tone", m, -23+10*math.Log10((1+math.Pow(10, -0.6))/2))
+}
+
+func TestLoudnessOfSilence(t *testing.T) {
+	m := newLoudnessMeter(44100, 2)
+	m.write(make([][2]float64, 44100*10))
+
+	if lufs, ok := m.integrated(); ok {
+		t.Errorf("expected no measurement of silence, got %.2f LUFS", lufs)
+	}
+
+	//too short to fill a single block
+	m = newLoudnessMeter(44100, 2)
+	writeSine(m, 44100, 997, -20, 0.2)
+
+	if lufs, ok := m.integrated(); ok {
+		t.Errorf("expected no measurement of 200ms, got %.2f LUFS", lufs)
+	}
+}
+
+func TestKWeightingAt48k(t *testing.T) {
+	shelf, highPass := kWeighting(48000)
+
+	//the coefficients BS.1770 publishes for 48kHz
+	want := []struct {
+		name      string
+		got, want float64
+	}{
+		{"shelf b0", shelf.b0, 1.53512485958697},
+		{"shelf b1", shelf.b1, -2.69169618940638},
+		{"shelf b2", shelf.b2, 1.19839281085285},
+		{"shelf a1", shelf.a1, -1.69065929318241},
+		{"shelf a2", shelf.a2, 0.73248077421585},
+		{"high pass a1", highPass.a1, -1.99004745483398},
+		{"high pass a2", highPass.a2, 0.99007225036621},
+	}
+
+	for _, c := range want {
+		if math.Abs(c.got-c.want) > 1e-6 {
+			t.Errorf("%s: expected %.14f, got %.14f", c.name, c.want, c.got)
+		}
+	}
+}
+
+func TestParseGain(t *testing.T) {
+	tests := []struct {
+		in   string
+		want float64
+		ok   bool
+	}{
+		{"-6.5 dB", -6.5, true},
+		{"+1.2 dB", 1.2, true},
+		{"-3.20db", -3.2, true},
+		{"3dB", 3, true},
+		{" 0.988547 ", 0.988547, true},
+		{"", 0, false},
+		{"loud", 0, false},
+		{"dB", 0, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := parseGain(tt.in)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("%q: expected %v, %v, got %v, %v", tt.in, tt.want, tt.ok, got, ok)
+		}
+	}
+}
+
+func TestReadReplayGain(t *testing.T) {
+	var sF SongFile
+	sF.readReplayGain(map[string]string{
+		"TXXX:REPLAYGAIN_TRACK_GAIN": "-6.5 dB",
+		"TXXX:REPLAYGAIN_TRACK_PEAK": "0.95",
+		"TXXX:REPLAYGAIN_ALBUM_GAIN": "+1.2 dB",
+		"TXXX:REPLAYGAIN_ALBUM_PEAK": "0.99",
+	})
+
+	want := Loudness{Track: -11.5, TrackPeak: 0.95, Album: -19.2, AlbumPeak: 0.99, Tagged: true}
+	if sF.Loudness == nil || *sF.Loudness != want {
+		t.Fatalf("expected %+v, got %+v", want, sF.Loudness)
+	}
+
+	sF = SongFile{}
+	sF.readReplayGain(map[string]string{"TXXX:REPLAYGAIN_ALBUM_GAIN": "+1.2 dB"})
+
+	if sF.Loudness != nil {
+		t.Fatalf("expected no loudness without a track gain, got %+v", sF.Loudness)
+	}
+}
+
+func TestNormalizedGain(t *testing.T) {
+	prev := normalization
+	defer func() { normalization = prev }()
+
+	sF := SongFile{Loudness: &Loudness{Track: -8, TrackPeak: 0.5, Album: -12, AlbumPeak: 0.9}}
+
+	tests := []struct {
+		name string
+		n    Normalization
+		l    Loudness
+		want float64
+	}{
+		{"off", Normalization{Mode: "off"}, *sF.Loudness, 1},
+		{"track", Normalization{Mode: "track"}, *sF.Loudness, math.Pow(10, -10.0/20)},
+		{"album", Normalization{Mode: "album"}, *sF.Loudness, math.Pow(10, -6.0/20)},
+		{"album falls back to track", Normalization{Mode: "album"}, Loudness{Track: -8, TrackPeak: 0.5}, math.Pow(10, -10.0/20)},
+		{"target", Normalization{Mode: "track", Target: -14}, *sF.Loudness, math.Pow(10, -6.0/20)},
+		{"clipping held back", Normalization{Mode: "track"}, Loudness{Track: -30, TrackPeak: 0.5}, 2},
+		{"clipping allowed", Normalization{Mode: "track", AllowClipping: true}, Loudness{Track: -30, TrackPeak: 0.5}, math.Pow(10, 12.0/20)},
+		{"silent", Normalization{Mode: "track"}, Loudness{Silent: true}, 1},
+	}
+
+	for _, tt := range tests {
+		if err := SetNormalization(tt.n); err != nil {
+			t.Fatal(err)
+		}
+
+		l := tt.l
+		sF.Loudness = &l
+
+		if got := sF.gain(); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: expected a gain of %v, got %v", tt.name, tt.want, got)
+		}
+	}
+
+	if err := SetNormalization(Normalization{Mode: "loud"}); err == nil {
+		t.Error("expected an unknown mode to be refused")
+	}
+
+	if err := SetNormalization(Normalization{Mode: "track", Target: 3}); err == nil {
+		t.Error("expected a target above 0 LUFS to be refused")
+	}
+}
+
+func TestMeasureAlbums(t *testing.T) {
+	songs := testSongs(20)
+	for i := range songs {
+		songs[i].PlayTime = time.Minute
+		songs[i].Loudness = &Loudness{Track: -10, TrackPeak: 0.5}
+	}
+
+	//the first album mixes loud and quiet tracks, and a silent one that doesn't count
+	for i := 5; i < 10; i++ {
+		songs[i].Loudness = &Loudness{Track: -20, TrackPeak: 0.8}
+	}
+	songs[9].Loudness = &Loudness{Silent: true}
+
+	//the second album has its loudness from its tags already
+	for i := 10; i < 20; i++ {
+		songs[i].Loudness = &Loudness{Track: -9, Album: -11, AlbumPeak: 1, Tagged: true}
+	}
+
+	l := &SongLibrary{Songs: songs}
+	l.measureAlbums()
+
+	want := 10 * math.Log10((5*math.Pow(10, -1)+4*math.Pow(10, -2))/9)
+	for i := 0; i < 9; i++ {
+		got := l.Songs[i].Loudness
+		if math.Abs(got.Album-want) > 1e-9 || got.AlbumPeak != 0.8 {
+			t.Fatalf("song %d: expected the album at %.3f LUFS peaking at 0.8, got %.3f peaking at %v", i, want, got.Album, got.AlbumPeak)
+		}
+	}
+
+	if l.Songs[9].Loudness.Album != 0 {
+		t.Errorf("expected the silent track to be left alone, got %+v", l.Songs[9].Loudness)
+	}
+
+	for i := 10; i < 20; i++ {
+		if got := l.Songs[i].Loudness; got.Album != -11 || got.AlbumPeak != 1 {
+			t.Fatalf("song %d: expected the tagged album loudness to be kept, got %+v", i, got)
+		}
+	}
+}
+
+func TestScanLeavesMeasuringToBackground(t *testing.T) {
+	defer useLibrary(nil)()
+
+	prev := normalization
+	defer func() { normalization = prev }()
+
+	if err := SetNormalization(Normalization{Mode: "track"}); err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "songplayer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	prevDir := libDir
+	libDir = dir
+	defer func() { libDir = prevDir }()
+
+	//songs under a minute and a half are left out of the library
+	if err = writeSilentMP3(filepath.Join(dir, "song.mp3"), 91*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	lib.LoadFromFiles()
+
+	if len(lib.Songs) != 1 {
+		t.Fatalf("expected the song to be found, got %d songs", len(lib.Songs))
+	}
+
+	for _, sF := range lib.Songs {
+		if sF.Loudness != nil {
+			t.Fatalf("%s: expected the scan not to measure, got %+v", sF.FileName, sF.Loudness)
+		}
+	}
+
+	lib.measureMissing()
+
+	for _, sF := range lib.Songs {
+		if sF.Loudness == nil || !sF.Loudness.Silent {
+			t.Fatalf("%s: expected the silent song to be measured in the background, got %+v", sF.FileName, sF.Loudness)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package songplayer
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+//WeightRule scales the rank of every song under a directory, or with a tag value, while the date
+//is within From and To. Ranges that end before they start wrap around the new year.
+type WeightRule struct {
+	Dir        string  `json:"dir,omitempty"`   //Dir is a directory prefix, relative to the music dir unless it starts with /
+	Tag        string  `json:"tag,omitempty"`   //Tag is the text field to compare, such as genre or artist
+	Value      string  `json:"value,omitempty"` //Value is compared to the tag without regard to case
+	Multiplier float64 `json:"multiplier"`
+	From       string  `json:"from,omitempty"` //From is the first day the rule applies, as MM-DD. Empty applies from the start of the year
+	To         string  `json:"to,omitempty"`   //To is the last day the rule applies, as MM-DD. Empty applies to the end of the year
+
+	from, to int //from and to are the range as month*100 + day
+}
+
+var weightRules []WeightRule
+
+//SetWeightRules checks and installs the rank multipliers applied when scoring.
+func SetWeightRules(rules []WeightRule) error {
+	parsed := make([]WeightRule, 0, len(rules))
+
+	for i, r := range rules {
+		if (r.Dir == "") == (r.Tag == "") {
+			return fmt.Errorf("weight rule %d: needs exactly one of dir or tag", i+1)
+		}
+
+		if r.Tag != "" {
+			if f, ok := queryFields[r.Tag]; !ok || f.kind != textField {
+				return fmt.Errorf("weight rule %d: %q isn't a text field", i+1, r.Tag)
+			}
+		}
+
+		if r.Multiplier <= 0 {
+			return fmt.Errorf("weight rule %d: multiplier must be above 0, got %v", i+1, r.Multiplier)
+		}
+
+		var err error
+		if r.from, err = monthDay(r.From, 101); err != nil {
+			return fmt.Errorf("weight rule %d: from: %v", i+1, err)
+		}
+
+		if r.to, err = monthDay(r.To, 1231); err != nil {
+			return fmt.Errorf("weight rule %d: to: %v", i+1, err)
+		}
+
+		parsed = append(parsed, r)
+	}
+
+	weightRules = parsed
+	return nil
+}
+
+//monthDay parses an MM-DD date into month*100 + day, or returns def for an empty one.
+func monthDay(s string, def int) (int, error) {
+	if s == "" {
+		return def, nil
+	}
+
+	t, err := time.Parse("01-02", s)
+	if err != nil {
+		return 0, fmt.Errorf("expected a date like 12-25, got %q", s)
+	}
+
+	return int(t.Month())*100 + t.Day(), nil
+}
+
+//activeOn reports whether the rule's date range covers t.
+func (r *WeightRule) activeOn(t time.Time) bool {
+	md := int(t.Month())*100 + t.Day()
+
+	if r.from <= r.to {
+		return md >= r.from && md <= r.to
+	}
+
+	return md >= r.from || md <= r.to
+}
+
+//matches reports whether the rule covers the song.
+func (r *WeightRule) matches(sF *SongFile) bool {
+	if r.Tag != "" {
+		return strings.EqualFold(queryFields[r.Tag].text(sF), r.Value)
+	}
+
+	dir := r.Dir
+	if !path.IsAbs(dir) {
+		dir = path.Join(libDir, dir)
+	}
+
+	return strings.HasPrefix(sF.FileName, path.Clean(dir)+"/")
+}
+
+//multiplier is the product of every rule covering the song at t, 1 when none do.
+func (sF *SongFile) multiplier(t time.Time) float64 {
+	m := 1.0
+
+	for i := range weightRules {
+		if weightRules[i].activeOn(t) && weightRules[i].matches(sF) {
+			m *= weightRules[i].Multiplier
+		}
+	}
+
+	return m
+}
+
+//scaleRank applies a multiplier to a rank. Ranks can be negative, so those are divided instead,
+//which keeps multipliers below 1 moving songs down the order and those above 1 moving them up.
+func scaleRank(rank, m float64) float64 {
+	if rank < 0 {
+		return rank / m
+	}
+
+	return rank * m
+}
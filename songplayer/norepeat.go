@@ -0,0 +1,111 @@
+package songplayer
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+//NoRepeatWindow keeps recently heard songs out of new batches, whether they were heard within the
+//last Hours or among the last Songs songs. Zero turns either limit off.
+type NoRepeatWindow struct {
+	Hours float64 `json:"hours"`
+	Songs int     `json:"songs"`
+}
+
+var noRepeat NoRepeatWindow
+
+//SetNoRepeatWindow configures how long a song sits out after it's been heard.
+func SetNoRepeatWindow(w NoRepeatWindow) {
+	if w.Hours < 0 {
+		w.Hours = 0
+	}
+
+	if w.Songs < 0 {
+		w.Songs = 0
+	}
+
+	noRepeat = w
+}
+
+//excludeRecent marks the eligible songs heard within the no-repeat window as ineligible, and returns
+//how many it excluded. The window is measured against the last time each song was heard, so it
+//holds across restarts. When the library is too small to fill a batch around it, the window
+//shrinks to the most recently heard songs that can be spared. In album mode, a track heard within
+//the window keeps its whole album out. Callers must hold lib.mu.
+func (lib *SongLibrary) excludeRecent(numEligible int) int {
+	if noRepeat == (NoRepeatWindow{}) {
+		return 0
+	}
+
+	var heard []*SongFile
+	eligibleIn := make(map[string]int)
+	for i := range lib.Songs {
+		if lib.Songs[i].lastHeard() > 0 {
+			heard = append(heard, &lib.Songs[i])
+		}
+
+		if lib.Songs[i].eligible {
+			eligibleIn[groupKey(&lib.Songs[i])]++
+		}
+	}
+
+	sort.SliceStable(heard, func(i, j int) bool { return heard[i].lastHeard() > heard[j].lastHeard() })
+
+	//the window covers the most recently heard songs, so it's always a prefix of heard
+	window := noRepeat.Songs
+	if window > len(heard) {
+		window = len(heard)
+	}
+
+	cutoff := now().Add(-time.Duration(noRepeat.Hours * float64(time.Hour))).Unix()
+	for window < len(heard) && noRepeat.Hours > 0 && heard[window].lastHeard() >= cutoff {
+		window++
+	}
+
+	//a group is kept out from its most recent listen on, so release[i] is how many eligible songs
+	//are let back in when the window shrinks past heard[i]
+	release := make([]int, len(heard))
+	seen := make(map[string]bool)
+	for i, sF := range heard {
+		if key := groupKey(sF); !seen[key] {
+			seen[key] = true
+			release[i] = eligibleIn[key]
+		}
+	}
+
+	excluded := 0
+	for _, n := range release[:window] {
+		excluded += n
+	}
+
+	needed := maxSize
+	if needed > numEligible {
+		needed = numEligible
+	}
+
+	full := window
+	for window > 0 && numEligible-excluded < needed {
+		window--
+		excluded -= release[window]
+	}
+
+	if window < full && !simulating {
+		fmt.Printf("no-repeat window shrunk from %d to %d songs to fill the batch\n", full, window)
+	}
+
+	inWindow := make(map[string]bool)
+	for _, sF := range heard[:window] {
+		inWindow[groupKey(sF)] = true
+	}
+
+	for i := range lib.Songs {
+		sF := &lib.Songs[i]
+		if sF.eligible && inWindow[groupKey(sF)] {
+			sF.eligible = false
+			sF.Breakdown.Excluded = "no-repeat window"
+		}
+	}
+
+	return excluded
+}
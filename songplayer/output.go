@@ -0,0 +1,230 @@
+package songplayer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/faiface/beep"
+)
+
+//Sink is somewhere the player can send its audio. It mirrors beep's speaker package, which the
+//speaker sink wraps.
+type Sink interface {
+	//Init prepares the sink for streams at sampleRate, pulling bufferSize samples at a time.
+	Init(sampleRate beep.SampleRate, bufferSize int) error
+	Play(s ...beep.Streamer)
+
+	//Lock stops the sink pulling samples, so the playing streamers can be changed safely.
+	Lock()
+	Unlock()
+}
+
+//OutputConfig picks the sink the player sends its audio to.
+type OutputConfig struct {
+	Sink       string  `json:"sink"`                  //Sink is speaker, null or wav. The speaker is used when empty
+	File       string  `json:"file,omitempty"`        //File is where the wav sink writes
+	Speed      float64 `json:"speed,omitempty"`       //Speed is how many times faster than real time the null and wav sinks run, 1 when 0
+	SampleRate int     `json:"sample_rate,omitempty"` //SampleRate every song is resampled to, 44100 when 0
+	BufferMS   int     `json:"buffer_ms,omitempty"`   //BufferMS is how much audio the output pulls at a time, 100 when 0
+}
+
+//sinks builds each kind of sink by name. The speaker registers itself, unless built headless.
+var sinks = map[string]func(cfg OutputConfig) (Sink, error){
+	"null": func(cfg OutputConfig) (Sink, error) { return newClockSink(cfg.Speed, nil), nil },
+	"wav":  newWavSink,
+}
+
+var (
+	output Sink
+
+	//outputRate and outputBuffer are the format the output is opened with. Songs at other sample
+	//rates are resampled to match, so the output never has to be re-opened.
+	outputRate   beep.SampleRate = 44100
+	outputBuffer                 = 100 * time.Millisecond
+)
+
+//SetOutput chooses where the player's audio goes, and the format it's sent in.
+func SetOutput(cfg OutputConfig) error {
+	if cfg.Sink == "" {
+		cfg.Sink = "speaker"
+	}
+
+	if cfg.SampleRate < 0 || cfg.BufferMS < 0 {
+		return fmt.Errorf("the output's sample rate and buffer can't be negative")
+	}
+
+	if cfg.SampleRate == 0 {
+		cfg.SampleRate = 44100
+	}
+
+	if cfg.BufferMS == 0 {
+		cfg.BufferMS = 100
+	}
+
+	newSink, ok := sinks[cfg.Sink]
+	if !ok {
+		return fmt.Errorf("no %s output in this build", cfg.Sink)
+	}
+
+	s, err := newSink(cfg)
+	if err != nil {
+		return err
+	}
+
+	output = s
+	outputRate = beep.SampleRate(cfg.SampleRate)
+	outputBuffer = time.Duration(cfg.BufferMS) * time.Millisecond
+	return nil
+}
+
+//clockSink pulls samples on its own clock instead of a sound card's, handing each buffer to write.
+type clockSink struct {
+	mu      sync.Mutex
+	mixer   beep.Mixer
+	samples [][2]float64
+	speed   float64
+	write   func(sr beep.SampleRate, samples [][2]float64)
+	done    chan struct{}
+}
+
+//newClockSink makes a sink consuming samples speed times faster than real time. write may be nil
+//to throw them away.
+func newClockSink(speed float64, write func(sr beep.SampleRate, samples [][2]float64)) *clockSink {
+	if speed <= 0 {
+		speed = 1
+	}
+
+	return &clockSink{speed: speed, write: write}
+}
+
+func (c *clockSink) Init(sampleRate beep.SampleRate, bufferSize int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.done != nil {
+		close(c.done)
+	}
+
+	c.mixer = beep.Mixer{}
+	c.samples = make([][2]float64, bufferSize)
+	c.done = make(chan struct{})
+
+	go c.run(sampleRate, sampleRate.D(bufferSize), c.samples, c.done)
+	return nil
+}
+
+func (c *clockSink) run(sr beep.SampleRate, perBuffer time.Duration, samples [][2]float64, done chan struct{}) {
+	tick := time.Duration(float64(perBuffer) / c.speed)
+	next := time.Now()
+
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+
+		c.mu.Lock()
+		c.mixer.Stream(samples)
+		c.mu.Unlock()
+
+		if c.write != nil {
+			c.write(sr, samples)
+		}
+
+		//keep to the clock, rather than drifting by however long each buffer took
+		next = next.Add(tick)
+		time.Sleep(time.Until(next))
+	}
+}
+
+func (c *clockSink) Play(s ...beep.Streamer) {
+	c.mu.Lock()
+	c.mixer.Add(s...)
+	c.mu.Unlock()
+}
+
+func (c *clockSink) Lock()   { c.mu.Lock() }
+func (c *clockSink) Unlock() { c.mu.Unlock() }
+
+//wavWriter appends 16 bit stereo samples to a wav file, keeping its header up to date after every
+//write so the file stays playable however the player exits.
+type wavWriter struct {
+	f          *os.File
+	sampleRate beep.SampleRate
+	dataLen    uint32
+	buf        []byte
+}
+
+func newWavSink(cfg OutputConfig) (Sink, error) {
+	if cfg.File == "" {
+		return nil, fmt.Errorf("the wav output needs a file to write to")
+	}
+
+	f, err := os.Create(cfg.File)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &wavWriter{f: f}
+	return newClockSink(cfg.Speed, w.write), nil
+}
+
+func (w *wavWriter) write(sr beep.SampleRate, samples [][2]float64) {
+	//the output is only opened once, so the first rate the sink sees is the one it keeps
+	if w.sampleRate == 0 {
+		w.sampleRate = sr
+	}
+
+	if len(w.buf) < len(samples)*4 {
+		w.buf = make([]byte, len(samples)*4)
+	}
+
+	for i := range samples {
+		for c := range samples[i] {
+			val := samples[i][c]
+			if val < -1 {
+				val = -1
+			} else if val > 1 {
+				val = 1
+			}
+
+			binary.LittleEndian.PutUint16(w.buf[i*4+c*2:], uint16(int16(val*(1<<15-1))))
+		}
+	}
+
+	if _, err := w.f.WriteAt(w.buf[:len(samples)*4], int64(44+w.dataLen)); err != nil {
+		fmt.Println("unable to write wav output: " + err.Error())
+		return
+	}
+
+	w.dataLen += uint32(len(samples) * 4)
+
+	if _, err := w.f.WriteAt(w.header(), 0); err != nil {
+		fmt.Println("unable to write wav header: " + err.Error())
+	}
+}
+
+//header is the 44 byte RIFF header of a 16 bit stereo PCM wav file.
+func (w *wavWriter) header() []byte {
+	h := make([]byte, 44)
+	le := binary.LittleEndian
+
+	copy(h[0:], "RIFF")
+	le.PutUint32(h[4:], 36+w.dataLen)
+	copy(h[8:], "WAVEfmt ")
+	le.PutUint32(h[16:], 16)
+	le.PutUint16(h[20:], 1) //PCM
+	le.PutUint16(h[22:], 2) //channels
+	le.PutUint32(h[24:], uint32(w.sampleRate))
+	le.PutUint32(h[28:], uint32(w.sampleRate)*4)
+	le.PutUint16(h[32:], 4)
+	le.PutUint16(h[34:], 16)
+	copy(h[36:], "data")
+	le.PutUint32(h[40:], w.dataLen)
+
+	return h
+}
@@ -0,0 +1,24 @@
+// +build !headless
+
+package songplayer
+
+import (
+	"github.com/faiface/beep"
+	"github.com/faiface/beep/speaker"
+)
+
+//speakerSink plays through the sound card. Headless builds leave it out, along with the audio
+//libraries it needs.
+type speakerSink struct{}
+
+func init() {
+	sinks["speaker"] = func(OutputConfig) (Sink, error) { return speakerSink{}, nil }
+}
+
+func (speakerSink) Init(sampleRate beep.SampleRate, bufferSize int) error {
+	return speaker.Init(sampleRate, bufferSize)
+}
+
+func (speakerSink) Play(s ...beep.Streamer) { speaker.Play(s...) }
+func (speakerSink) Lock()                   { speaker.Lock() }
+func (speakerSink) Unlock()                 { speaker.Unlock() }
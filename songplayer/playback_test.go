@@ -0,0 +1,332 @@
+package songplayer
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+//playbackSpeed is how many times faster than real time the null sink plays the test songs. Songs are
+//decoded as they play, so going much faster leaves the race detector unable to keep up.
+const playbackSpeed = 8
+
+var initOutput sync.Once
+
+//writeSilentMP3 writes an MPEG-1 layer III file of silence lasting about d, at 32kbps and 44.1kHz.
+//Every frame is a header followed by side information and main data left at zero.
+func writeSilentMP3(name string, d time.Duration) error {
+	frame := make([]byte, 104)
+	copy(frame, []byte{0xff, 0xfb, 0x10, 0x00})
+
+	frames := int(d.Seconds()*44100)/1152 + 1
+
+	var b bytes.Buffer
+	for i := 0; i < frames; i++ {
+		b.Write(frame)
+	}
+
+	return ioutil.WriteFile(name, b.Bytes(), 0644)
+}
+
+//useNullOutput sends the player's audio to the null sink, the first time it's called.
+func useNullOutput(t *testing.T) {
+	initOutput.Do(func() {
+		if err := SetOutput(OutputConfig{Sink: "null", Speed: playbackSpeed}); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+//usePlaybackLibrary writes n silent songs of length d to a temporary library dir and swaps in a
+//library of them, played through the null sink. Returns a func undoing it all.
+func usePlaybackLibrary(t *testing.T, n int, d time.Duration) func() {
+	dir, err := ioutil.TempDir("", "songplayer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	songs := testSongs(n)
+	for i := range songs {
+		songs[i].FileName = filepath.Join(dir, fmt.Sprintf("song%d.mp3", i))
+
+		if err = writeSilentMP3(songs[i].FileName, d); err != nil {
+			t.Fatal(err)
+		}
+
+		if err = songs[i].loadPlayTime(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	useNullOutput(t)
+
+	restore := useLibrary(songs)
+	prevDir := libDir
+	libDir = dir
+
+	//the test songs are short, so only the very end counts as the outro
+	weights.OutroSeconds = 1
+
+	return func() {
+		resetDeck()
+		restore()
+		libDir = prevDir
+		_ = os.RemoveAll(dir)
+	}
+}
+
+//resetDeck drops whatever the deck is holding on to, so the next test starts from nothing.
+func resetDeck() {
+	player.unprime()
+
+	output.Lock()
+	player.dropQueued(nil)
+	if player.ctrl != nil {
+		player.ctrl.Paused = false
+	}
+	output.Unlock()
+
+	for {
+		select {
+		case <-trackDone:
+		default:
+			return
+		}
+	}
+}
+
+//stateWatcher keeps up with the state the player sends to the ui.
+type stateWatcher struct {
+	mu     sync.Mutex
+	latest PlayingSong
+	stop   chan struct{}
+}
+
+func watchState() *stateWatcher {
+	w := &stateWatcher{stop: make(chan struct{})}
+
+	go func() {
+		for {
+			select {
+			case ps := <-SongState:
+				w.mu.Lock()
+				w.latest = ps
+				w.mu.Unlock()
+			case <-w.stop:
+				return
+			}
+		}
+	}()
+
+	return w
+}
+
+func (w *stateWatcher) close() {
+	close(w.stop)
+}
+
+func (w *stateWatcher) state() PlayingSong {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.latest
+}
+
+//waitFor waits for the player to report a state matching cond.
+func (w *stateWatcher) waitFor(t *testing.T, what string, cond func(ps PlayingSong) bool) PlayingSong {
+	deadline := time.Now().Add(10 * time.Second)
+
+	for time.Now().Before(deadline) {
+		if ps := w.state(); cond(ps) {
+			return ps
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	t.Fatalf("timed out waiting for %s", what)
+	return PlayingSong{}
+}
+
+//startPlaying plays sF in the background, reporting whether it asked to exit once it's done.
+func startPlaying(sF *SongFile) chan bool {
+	done := make(chan bool, 1)
+	go func() {
+		done <- sF.play()
+	}()
+
+	return done
+}
+
+func awaitPlay(t *testing.T, done chan bool) {
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the song to finish")
+	}
+}
+
+//playInfo looks up the song named name, wherever the last compute put it.
+func playInfo(name string) PlayInfo {
+	lib.mu.RLock()
+	defer lib.mu.RUnlock()
+
+	return lib.findSong(name).PlayInfo
+}
+
+func TestPlaybackCompletes(t *testing.T) {
+	defer usePlaybackLibrary(t, 3, 8*time.Second)()
+
+	w := watchState()
+	defer w.close()
+
+	sF := player.upNext()
+	name := sF.FileName
+
+	if lib.NextSong != 1 {
+		t.Fatalf("expected the first song of the batch to be served, next song is %d", lib.NextSong)
+	}
+
+	awaitPlay(t, startPlaying(sF))
+
+	pI := playInfo(name)
+	if pI.TotalPlays != 1 || pI.TotalSkips != 0 || pI.LastPlayed == 0 {
+		t.Fatalf("expected one play and no skips, got %d plays and %d skips", pI.TotalPlays, pI.TotalSkips)
+	}
+
+	//the next song was lined up before this one finished
+	if lib.NextSong != 2 {
+		t.Fatalf("expected the next song to be primed, next song is %d", lib.NextSong)
+	}
+}
+
+func TestPlaybackSkip(t *testing.T) {
+	defer usePlaybackLibrary(t, 3, 8*time.Second)()
+
+	w := watchState()
+	defer w.close()
+
+	sF := player.upNext()
+	name := sF.FileName
+	done := startPlaying(sF)
+
+	w.waitFor(t, "the song to get 2 seconds in", func(ps PlayingSong) bool {
+		return ps.CurrentSong == filepath.Base(name) && ps.SongTime >= 2*time.Second
+	})
+
+	PlayerSignal <- Command{Signal: SignalSkip}
+	awaitPlay(t, done)
+
+	pI := playInfo(name)
+	if pI.TotalSkips != 1 || pI.ConsecutiveSkips != 1 || pI.TotalPlays != 0 {
+		t.Fatalf("expected one skip and no plays, got %d skips and %d plays", pI.TotalSkips, pI.TotalPlays)
+	}
+
+	if pI.LastSkipPosition < 2*time.Second || pI.LastSkipPosition >= 7*time.Second {
+		t.Fatalf("expected the skip to be recorded soon after 2s, got %v", pI.LastSkipPosition)
+	}
+
+	//the next song of the batch was lined up while this one played, and plays once it's skipped
+	if next := player.upNext(); next.FileName == name || lib.NextSong != 2 {
+		t.Fatalf("expected the skip to move on to the next song of the batch, got %s at %d", next.FileName, lib.NextSong)
+	}
+}
+
+func TestPlaybackPauseResume(t *testing.T) {
+	defer usePlaybackLibrary(t, 3, 8*time.Second)()
+
+	w := watchState()
+	defer w.close()
+
+	sF := player.upNext()
+	name := sF.FileName
+	done := startPlaying(sF)
+
+	w.waitFor(t, "the song to get 2 seconds in", func(ps PlayingSong) bool {
+		return ps.CurrentSong == filepath.Base(name) && ps.SongTime >= 2*time.Second
+	})
+
+	PlayerSignal <- Command{Signal: SignalPause}
+
+	//give the output a buffer to notice, then make sure nothing more plays
+	time.Sleep(100 * time.Millisecond)
+	pausedAt := w.state().SongTime
+	time.Sleep(300 * time.Millisecond)
+
+	if at := w.state().SongTime; at != pausedAt {
+		t.Fatalf("expected playback to hold at %v while paused, got to %v", pausedAt, at)
+	}
+
+	PlayerSignal <- Command{Signal: SignalPlay}
+	awaitPlay(t, done)
+
+	pI := playInfo(name)
+	if pI.TotalPlays != 1 || pI.TotalSkips != 0 {
+		t.Fatalf("expected a paused song to count as played, got %d plays and %d skips", pI.TotalPlays, pI.TotalSkips)
+	}
+}
+
+func TestPlayingSongSitsOutNextBatch(t *testing.T) {
+	defer usePlaybackLibrary(t, 3, 8*time.Second)()
+	defer nowPlaying.Store("")
+
+	//a batch of one, so the next song is picked by a compute run while the first one plays, and a
+	//favourite that would win that compute too if it were let in
+	SetPlaylistMaxSize(1)
+	lib.Songs[0].Score = 100
+	lib.Songs[0].Rating = 5
+	lib.Songs[0].Favourite = true
+
+	w := watchState()
+	defer w.close()
+
+	sF := player.upNext()
+	name := sF.FileName
+	if name != lib.Songs[0].FileName {
+		t.Fatalf("expected the favourite to be picked first, got %s", name)
+	}
+
+	awaitPlay(t, startPlaying(sF))
+
+	//the song lined up while the favourite played
+	if next := player.upNext(); next.FileName == name {
+		t.Fatalf("expected the song still playing to be kept out of the next batch, got %s again", name)
+	}
+}
+
+func TestPlaybackSeek(t *testing.T) {
+	defer usePlaybackLibrary(t, 3, 8*time.Second)()
+
+	w := watchState()
+	defer w.close()
+
+	sF := player.upNext()
+	name := sF.FileName
+	done := startPlaying(sF)
+
+	w.waitFor(t, "the song to start", func(ps PlayingSong) bool {
+		return ps.CurrentSong == filepath.Base(name)
+	})
+
+	//the time sent to the ui picks up from where the seek landed
+	PlayerSignal <- Command{Signal: SignalSeekTo, Value: 5000}
+	w.waitFor(t, "the song to be at 5 seconds", func(ps PlayingSong) bool {
+		return ps.CurrentSong == filepath.Base(name) && ps.SongTime >= 5*time.Second && ps.SongTime < 6*time.Second
+	})
+
+	PlayerSignal <- Command{Signal: SignalSeek, Value: -3000}
+	w.waitFor(t, "the song to go back to 2 seconds", func(ps PlayingSong) bool {
+		return ps.CurrentSong == filepath.Base(name) && ps.SongTime >= 2*time.Second && ps.SongTime < 4*time.Second
+	})
+
+	awaitPlay(t, done)
+
+	if pI := playInfo(name); pI.TotalPlays != 1 || pI.TotalSkips != 0 {
+		t.Fatalf("expected a song seeked through to count as played, got %d plays and %d skips", pI.TotalPlays, pI.TotalSkips)
+	}
+}
@@ -0,0 +1,93 @@
+package songplayer
+
+import (
+	"fmt"
+)
+
+var (
+	smartPlaylists = make(map[string]*Query)
+
+	//activePlaylist restricts the shuffle to its matches, when set.
+	activePlaylist *Query
+)
+
+//SetSmartPlaylists parses the rules of each named smart playlist.
+func SetSmartPlaylists(rules map[string]string) error {
+	parsed := make(map[string]*Query, len(rules))
+
+	for name, rule := range rules {
+		q, err := ParseQuery(rule)
+		if err != nil {
+			return fmt.Errorf("smart playlist %q: %v", name, err)
+		}
+
+		parsed[name] = q
+	}
+
+	smartPlaylists = parsed
+	return nil
+}
+
+//SmartPlaylist looks up a smart playlist by name.
+func SmartPlaylist(name string) (*Query, error) {
+	q, ok := smartPlaylists[name]
+	if !ok {
+		return nil, fmt.Errorf("no smart playlist named %q", name)
+	}
+
+	return q, nil
+}
+
+//UsePlaylist restricts the shuffle to the songs currently matching the named smart playlist.
+//An empty name lifts the restriction.
+func UsePlaylist(name string) error {
+	if name == "" {
+		activePlaylist = nil
+		return nil
+	}
+
+	q, err := SmartPlaylist(name)
+	if err != nil {
+		return err
+	}
+
+	activePlaylist = q
+	return nil
+}
+
+//Matches returns every song in the library matching q.
+func (lib *SongLibrary) Matches(q *Query) []SongFile {
+	lib.mu.RLock()
+	defer lib.mu.RUnlock()
+
+	var songs []SongFile
+	for i := range lib.Songs {
+		if q.Match(&lib.Songs[i]) {
+			songs = append(songs, lib.Songs[i])
+		}
+	}
+
+	return songs
+}
+
+//ignorePlaylist lets the songs kept out only by the active playlist back in, for when the playlist
+//has nothing left to play. Playlists like "plays < 1" run dry as they're listened to, and the
+//library carries on rather than stopping. Returns how many songs it let in. Callers must hold lib.mu.
+func (lib *SongLibrary) ignorePlaylist() int {
+	var admitted int
+
+	for i := range lib.Songs {
+		sF := &lib.Songs[i]
+		if sF.Breakdown.Excluded == "not in the playlist" {
+			sF.Breakdown.Excluded = ""
+			sF.eligible = true
+			admitted++
+		}
+	}
+
+	if admitted > 0 && !simulating {
+		fmt.Println("no songs in the playlist can be played, shuffling the whole library until some can")
+	}
+
+	return admitted
+}
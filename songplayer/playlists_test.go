@@ -0,0 +1,31 @@
+package songplayer
+
+import (
+	"testing"
+)
+
+func TestDrainedPlaylistFallsBackToLibrary(t *testing.T) {
+	defer useLibrary(testSongs(20))()
+
+	if err := SetSmartPlaylists(map[string]string{"unheard": "plays < 1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := UsePlaylist("unheard"); err != nil {
+		t.Fatal(err)
+	}
+	defer UsePlaylist("")
+
+	for i := range lib.Songs {
+		lib.Songs[i].TotalPlays = 1
+	}
+
+	sF := lib.nextSong()
+	if sF == nil {
+		t.Fatal("no song picked once the playlist ran dry")
+	}
+
+	if lib.BatchSize == 0 {
+		t.Fatal("expected the whole library to be shuffled once the playlist ran dry")
+	}
+}
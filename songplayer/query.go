@@ -0,0 +1,523 @@
+package songplayer
+
+import (
+	"fmt"
+	"math"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+//Query is a parsed smart playlist rule, such as `genre = "jazz" and plays < 3 and duration > 4m`.
+//
+//Comparisons are joined with and, or, not and parentheses. Fields are:
+//  - text: file, name, dir, artist, album, title, genre. Compared with = and != (ignoring case),
+//    or ~ and !~ against a regular expression
+//  - numbers: plays, skips, score, rating, track
+//  - durations: duration, last_played, last_skipped. The last two are how long ago the song was
+//    played or skipped, so `last_played > 90d` matches songs not played in 90 days, and songs
+//    never played at all. Durations are written like 90s, 4m, 1h30m, 90d or 2w
+//  - flags: favourite, never_play, compared with true or false
+type Query struct {
+	src  string
+	root queryNode
+}
+
+//QueryError points out where in a query parsing went wrong.
+type QueryError struct {
+	Query string
+	Pos   int
+	Msg   string
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("column %d: %s\n  %s\n  %s^", e.Pos+1, e.Msg, e.Query, strings.Repeat(" ", e.Pos))
+}
+
+type fieldKind int
+
+const (
+	textField fieldKind = iota
+	numberField
+	durationField
+	flagField
+)
+
+var kindNames = map[fieldKind]string{
+	textField:     "text",
+	numberField:   "a number",
+	durationField: "a duration",
+	flagField:     "true or false",
+}
+
+//queryField describes how to read one field from a song.
+type queryField struct {
+	kind     fieldKind
+	text     func(sF *SongFile) string
+	number   func(sF *SongFile) float64
+	duration func(sF *SongFile) time.Duration
+	flag     func(sF *SongFile) bool
+}
+
+//age is how long ago a unix time was, or forever if it never happened.
+func age(unix int64) time.Duration {
+	if unix == 0 {
+		return time.Duration(math.MaxInt64)
+	}
+
+	return now().Sub(time.Unix(unix, 0))
+}
+
+var queryFields = map[string]queryField{
+	"file":   {kind: textField, text: func(sF *SongFile) string { return sF.FileName }},
+	"name":   {kind: textField, text: func(sF *SongFile) string { return path.Base(sF.FileName) }},
+	"dir":    {kind: textField, text: func(sF *SongFile) string { return sF.Dir() }},
+	"artist": {kind: textField, text: func(sF *SongFile) string { return sF.Artist() }},
+	"album":  {kind: textField, text: func(sF *SongFile) string { return sF.Tags.Album }},
+	"title":  {kind: textField, text: func(sF *SongFile) string { return sF.Tags.Title }},
+	"genre":  {kind: textField, text: func(sF *SongFile) string { return sF.Tags.Genre }},
+
+	"plays":  {kind: numberField, number: func(sF *SongFile) float64 { return float64(sF.TotalPlays) }},
+	"skips":  {kind: numberField, number: func(sF *SongFile) float64 { return float64(sF.TotalSkips) }},
+	"score":  {kind: numberField, number: func(sF *SongFile) float64 { return sF.Score }},
+	"rating": {kind: numberField, number: func(sF *SongFile) float64 { return float64(sF.Rating) }},
+	"track":  {kind: numberField, number: func(sF *SongFile) float64 { return float64(sF.Tags.Track) }},
+
+	"duration":     {kind: durationField, duration: func(sF *SongFile) time.Duration { return sF.PlayTime }},
+	"last_played":  {kind: durationField, duration: func(sF *SongFile) time.Duration { return age(sF.LastPlayed) }},
+	"last_skipped": {kind: durationField, duration: func(sF *SongFile) time.Duration { return age(sF.LastSkipped) }},
+
+	"favourite":  {kind: flagField, flag: func(sF *SongFile) bool { return sF.Favourite }},
+	"never_play": {kind: flagField, flag: func(sF *SongFile) bool { return sF.Banned }},
+}
+
+func fieldNames() string {
+	names := make([]string, 0, len(queryFields))
+	for n := range queryFields {
+		names = append(names, n)
+	}
+
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+//ParseQuery parses a smart playlist rule.
+func ParseQuery(src string) (*Query, error) {
+	p := &queryParser{src: src}
+
+	if err := p.lex(); err != nil {
+		return nil, err
+	}
+
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, p.errorf(tok, "expected and, or or the end of the query, found %s", tok)
+	}
+
+	return &Query{src: src, root: root}, nil
+}
+
+//Match reports whether the song satisfies the query.
+func (q *Query) Match(sF *SongFile) bool {
+	return q.root.match(sF)
+}
+
+func (q *Query) String() string {
+	return q.src
+}
+
+/* Lexing */
+
+type tokKind int
+
+const (
+	tokEOF tokKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokDuration
+	tokOp
+	tokLParen
+	tokRParen
+)
+
+type token struct {
+	kind tokKind
+	text string
+	pos  int
+	num  float64
+	dur  time.Duration
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "the end of the query"
+	case tokString:
+		return strconv.Quote(t.text)
+	default:
+		return "\"" + t.text + "\""
+	}
+}
+
+var durationUnits = map[byte]time.Duration{
+	's': time.Second,
+	'm': time.Minute,
+	'h': time.Hour,
+	'd': 24 * time.Hour,
+	'w': 7 * 24 * time.Hour,
+}
+
+type queryParser struct {
+	src  string
+	toks []token
+	at   int
+}
+
+func (p *queryParser) errorf(tok token, format string, args ...interface{}) error {
+	return &QueryError{Query: p.src, Pos: tok.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *queryParser) lex() error {
+	src := p.src
+
+	for i := 0; i < len(src); {
+		c := src[i]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '(':
+			p.toks = append(p.toks, token{kind: tokLParen, text: "(", pos: i})
+			i++
+		case c == ')':
+			p.toks = append(p.toks, token{kind: tokRParen, text: ")", pos: i})
+			i++
+		case strings.ContainsRune("=!<>~", rune(c)):
+			op := string(c)
+			if i+1 < len(src) && (src[i+1] == '=' || (c == '!' && src[i+1] == '~')) {
+				op += string(src[i+1])
+			}
+
+			if op == "!" {
+				return &QueryError{Query: src, Pos: i, Msg: "expected != or !~"}
+			}
+
+			p.toks = append(p.toks, token{kind: tokOp, text: op, pos: i})
+			i += len(op)
+		case c == '"':
+			j := i + 1
+			var sb strings.Builder
+			for ; j < len(src) && src[j] != '"'; j++ {
+				if src[j] == '\\' && j+1 < len(src) {
+					j++
+				}
+				sb.WriteByte(src[j])
+			}
+
+			if j >= len(src) {
+				return &QueryError{Query: src, Pos: i, Msg: "string is never closed"}
+			}
+
+			p.toks = append(p.toks, token{kind: tokString, text: sb.String(), pos: i})
+			i = j + 1
+		case c >= '0' && c <= '9' || c == '-' || c == '.':
+			tok, n, err := lexNumber(src, i)
+			if err != nil {
+				return err
+			}
+
+			p.toks = append(p.toks, tok)
+			i += n
+		case unicode.IsLetter(rune(c)) || c == '_':
+			j := i
+			for j < len(src) && (unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j])) || src[j] == '_') {
+				j++
+			}
+
+			p.toks = append(p.toks, token{kind: tokIdent, text: strings.ToLower(src[i:j]), pos: i})
+			i = j
+		default:
+			return &QueryError{Query: src, Pos: i, Msg: fmt.Sprintf("unexpected character %q", c)}
+		}
+	}
+
+	p.toks = append(p.toks, token{kind: tokEOF, pos: len(src)})
+	return nil
+}
+
+//lexNumber reads a number, or a duration if it's followed by units, returning how many bytes it used.
+func lexNumber(src string, start int) (token, int, error) {
+	i := start
+	var dur time.Duration
+	isDur := false
+
+	for {
+		j := i
+		if j < len(src) && src[j] == '-' && j == start {
+			j++
+		}
+
+		for j < len(src) && (src[j] >= '0' && src[j] <= '9' || src[j] == '.') {
+			j++
+		}
+
+		num, err := strconv.ParseFloat(src[i:j], 64)
+		if err != nil {
+			return token{}, 0, &QueryError{Query: src, Pos: i, Msg: fmt.Sprintf("%q is not a number", src[i:j])}
+		}
+
+		unit, ok := time.Duration(0), false
+		if j < len(src) {
+			unit, ok = durationUnits[src[j]]
+		}
+
+		if !ok {
+			if isDur {
+				return token{}, 0, &QueryError{Query: src, Pos: j, Msg: "expected a unit (s, m, h, d or w) to finish the duration"}
+			}
+
+			if j < len(src) && unicode.IsLetter(rune(src[j])) {
+				return token{}, 0, &QueryError{Query: src, Pos: j, Msg: fmt.Sprintf("unknown duration unit %q, use s, m, h, d or w", src[j])}
+			}
+
+			return token{kind: tokNumber, text: src[start:j], pos: start, num: num}, j - start, nil
+		}
+
+		isDur = true
+		dur += time.Duration(num * float64(unit))
+		i = j + 1
+
+		//durations like 1h30m carry on with another number
+		if i >= len(src) || src[i] < '0' || src[i] > '9' {
+			return token{kind: tokDuration, text: src[start:i], pos: start, dur: dur}, i - start, nil
+		}
+	}
+}
+
+/* Parsing */
+
+type queryNode interface {
+	match(sF *SongFile) bool
+}
+
+type andNode struct{ l, r queryNode }
+type orNode struct{ l, r queryNode }
+type notNode struct{ n queryNode }
+
+func (n andNode) match(sF *SongFile) bool { return n.l.match(sF) && n.r.match(sF) }
+func (n orNode) match(sF *SongFile) bool  { return n.l.match(sF) || n.r.match(sF) }
+func (n notNode) match(sF *SongFile) bool { return !n.n.match(sF) }
+
+type compareNode struct {
+	field queryField
+	op    string
+	text  string
+	re    *regexp.Regexp
+	num   float64
+	dur   time.Duration
+	flag  bool
+}
+
+func (p *queryParser) peek() token {
+	return p.toks[p.at]
+}
+
+func (p *queryParser) next() token {
+	tok := p.toks[p.at]
+	if tok.kind != tokEOF {
+		p.at++
+	}
+
+	return tok
+}
+
+func (p *queryParser) isKeyword(word string) bool {
+	tok := p.peek()
+	return tok.kind == tokIdent && tok.text == word
+}
+
+func (p *queryParser) parseOr() (queryNode, error) {
+	l, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isKeyword("or") {
+		p.next()
+
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+
+		l = orNode{l, r}
+	}
+
+	return l, nil
+}
+
+func (p *queryParser) parseAnd() (queryNode, error) {
+	l, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isKeyword("and") {
+		p.next()
+
+		r, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+
+		l = andNode{l, r}
+	}
+
+	return l, nil
+}
+
+func (p *queryParser) parseNot() (queryNode, error) {
+	if p.isKeyword("not") {
+		p.next()
+
+		n, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+
+		return notNode{n}, nil
+	}
+
+	if p.peek().kind == tokLParen {
+		open := p.next()
+
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		if tok := p.next(); tok.kind != tokRParen {
+			return nil, p.errorf(tok, "expected ) to close the ( at column %d, found %s", open.pos+1, tok)
+		}
+
+		return n, nil
+	}
+
+	return p.parseComparison()
+}
+
+var validOps = map[fieldKind][]string{
+	textField:     {"=", "!=", "~", "!~"},
+	numberField:   {"=", "!=", "<", "<=", ">", ">="},
+	durationField: {"=", "!=", "<", "<=", ">", ">="},
+	flagField:     {"=", "!="},
+}
+
+func (p *queryParser) parseComparison() (queryNode, error) {
+	name := p.next()
+	if name.kind != tokIdent {
+		return nil, p.errorf(name, "expected a field name, found %s", name)
+	}
+
+	field, ok := queryFields[name.text]
+	if !ok {
+		return nil, p.errorf(name, "unknown field %s, fields are: %s", name, fieldNames())
+	}
+
+	op := p.next()
+	if op.kind != tokOp {
+		return nil, p.errorf(op, "expected a comparison after %s, found %s", name, op)
+	}
+
+	valid := false
+	for _, v := range validOps[field.kind] {
+		valid = valid || v == op.text
+	}
+
+	if !valid {
+		return nil, p.errorf(op, "%s can't be compared with %s, use one of %s",
+			name, op.text, strings.Join(validOps[field.kind], " "))
+	}
+
+	val := p.next()
+	node := compareNode{field: field, op: op.text}
+
+	switch {
+	case field.kind == textField && val.kind == tokString:
+		node.text = strings.ToLower(val.text)
+		if op.text == "~" || op.text == "!~" {
+			re, err := regexp.Compile("(?i)" + val.text)
+			if err != nil {
+				return nil, p.errorf(val, "invalid pattern: %v", err)
+			}
+
+			node.re = re
+		}
+	case field.kind == numberField && val.kind == tokNumber:
+		node.num = val.num
+	case field.kind == durationField && val.kind == tokDuration:
+		node.dur = val.dur
+	case field.kind == flagField && val.kind == tokIdent && (val.text == "true" || val.text == "false"):
+		node.flag = val.text == "true"
+	default:
+		hint := ""
+		if field.kind == durationField && val.kind == tokNumber {
+			hint = ", add a unit such as " + val.text + "m or " + val.text + "d"
+		} else if field.kind == textField && val.kind == tokIdent {
+			hint = ", quote it: \"" + val.text + "\""
+		}
+
+		return nil, p.errorf(val, "%s is compared with %s, found %s%s", name, kindNames[field.kind], val, hint)
+	}
+
+	return node, nil
+}
+
+func (n compareNode) match(sF *SongFile) bool {
+	switch n.field.kind {
+	case textField:
+		v := n.field.text(sF)
+		switch n.op {
+		case "~":
+			return n.re.MatchString(v)
+		case "!~":
+			return !n.re.MatchString(v)
+		case "=":
+			return strings.ToLower(v) == n.text
+		default:
+			return strings.ToLower(v) != n.text
+		}
+	case numberField:
+		return compareFloats(n.field.number(sF), n.op, n.num)
+	case durationField:
+		return compareFloats(float64(n.field.duration(sF)), n.op, float64(n.dur))
+	default:
+		return (n.field.flag(sF) == n.flag) == (n.op == "=")
+	}
+}
+
+func compareFloats(a float64, op string, b float64) bool {
+	switch op {
+	case "=":
+		return a == b
+	case "!=":
+		return a != b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	default:
+		return a >= b
+	}
+}
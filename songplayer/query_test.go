@@ -0,0 +1,179 @@
+package songplayer
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+//querySong is the song every match test is run against.
+func querySong(at time.Time) *SongFile {
+	return &SongFile{
+		FileName: "/music/Miles Davis/Kind of Blue/01 So What.mp3",
+		PlayTime: 9*time.Minute + 22*time.Second,
+		Tags: Tags{
+			Title:  "So What",
+			Artist: "Miles Davis",
+			Album:  "Kind of Blue",
+			Genre:  "Jazz",
+			Track:  1,
+		},
+		PlayInfo: PlayInfo{
+			Score:      12.5,
+			TotalPlays: 2,
+			TotalSkips: 1,
+			LastPlayed: at.Add(-100 * 24 * time.Hour).Unix(),
+			Rating:     4,
+			Favourite:  true,
+		},
+	}
+}
+
+func TestQueryMatch(t *testing.T) {
+	at := time.Unix(1700000000, 0)
+	prevNow := now
+	now = func() time.Time { return at }
+	defer func() { now = prevNow }()
+
+	sF := querySong(at)
+
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		//text fields, ignoring case
+		{`artist = "miles davis"`, true},
+		{`artist != "Miles Davis"`, false},
+		{`genre = "rock"`, false},
+		{`genre != "rock"`, true},
+		{`title ~ "^so"`, true},
+		{`title ~ "^what"`, false},
+		{`album !~ "blue$"`, false},
+		{`album !~ "green"`, true},
+		{`name = "01 so what.mp3"`, true},
+		{`dir = "/music/miles davis/kind of blue"`, true},
+		{`file ~ "\\.mp3$"`, true},
+		{`genre = "say \"jazz\""`, false},
+
+		//numbers
+		{`plays = 2`, true},
+		{`plays != 2`, false},
+		{`plays < 3`, true},
+		{`plays < 2`, false},
+		{`plays <= 2`, true},
+		{`plays > 2`, false},
+		{`plays >= 2`, true},
+		{`skips = 1`, true},
+		{`score > 12.25`, true},
+		{`score > -1`, true},
+		{`rating >= 4`, true},
+		{`track = 1`, true},
+
+		//durations, and how long ago things happened
+		{`duration > 9m`, true},
+		{`duration = 9m22s`, true},
+		{`duration < 9m22s`, false},
+		{`duration <= 9m22s`, true},
+		{`duration >= 10m`, false},
+		{`duration != 9m`, true},
+		{`duration > 1.5h`, false},
+		{`duration < 562s`, false},
+		{`last_played > 90d`, true},
+		{`last_played >= 2400h`, true},
+		{`last_played > 15w`, false},
+		{`last_played < 2w`, false},
+		{`last_skipped > 10000d`, true},
+
+		//flags
+		{`favourite = true`, true},
+		{`favourite != false`, true},
+		{`never_play = true`, false},
+		{`never_play != true`, true},
+
+		//combinations
+		{`genre = "jazz" and plays < 3 and duration > 4m`, true},
+		{`genre = "rock" or rating = 4`, true},
+		{`genre = "rock" or rating = 5`, false},
+		{`not favourite = true`, false},
+		{`not not favourite = true`, true},
+		{`GENRE = "JAZZ" AND PLAYS < 3`, true},
+
+		//and binds tighter than or, and not tighter than and
+		{`rating = 4 or genre = "rock" and plays = 5`, true},
+		{`(rating = 4 or genre = "rock") and plays = 5`, false},
+		{`genre = "rock" and plays = 2 or rating = 4`, true},
+		{`genre = "rock" and (plays = 2 or rating = 4)`, false},
+		{`not genre = "rock" and plays = 5`, false},
+		{`not (genre = "rock" and plays = 5)`, true},
+		{`((plays = 2))`, true},
+	}
+
+	for _, tt := range tests {
+		q, err := ParseQuery(tt.query)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.query, err)
+			continue
+		}
+
+		if got := q.Match(sF); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.query, tt.want, got)
+		}
+	}
+}
+
+func TestQueryErrors(t *testing.T) {
+	tests := []struct {
+		query  string
+		column int
+		msg    string
+	}{
+		{`plays <`, 8, `"plays" is compared with a number, found the end of the query`},
+		{`plays ! 3`, 7, `expected != or !~`},
+		{`genre = "jazz`, 9, `string is never closed`},
+		{`plays < 3x`, 10, `unknown duration unit 'x', use s, m, h, d or w`},
+		{`duration > 1h30`, 16, `expected a unit (s, m, h, d or w) to finish the duration`},
+		{`duration > 5`, 12, `"duration" is compared with a duration, found "5", add a unit such as 5m or 5d`},
+		{`genre = jazz`, 9, `"genre" is compared with text, found "jazz", quote it: "jazz"`},
+		{`favourite = yes`, 13, `"favourite" is compared with true or false, found "yes"`},
+		{`colour = "red"`, 1, `unknown field "colour", fields are: album, artist, dir, duration, favourite, file, genre, last_played, last_skipped, name, never_play, plays, rating, score, skips, title, track`},
+		{`plays ~ "1"`, 7, `"plays" can't be compared with ~, use one of = != < <= > >=`},
+		{`plays 3`, 7, `expected a comparison after "plays", found "3"`},
+		{`plays < 1.2.3`, 9, `"1.2.3" is not a number`},
+		{`plays < 3 $`, 11, `unexpected character '$'`},
+		{`(plays < 3`, 11, `expected ) to close the ( at column 1, found the end of the query`},
+		{`plays < 3)`, 10, `expected and, or or the end of the query, found ")"`},
+		{`plays < 3 and`, 14, `expected a field name, found the end of the query`},
+		{`title ~ "("`, 9, `invalid pattern: `},
+		{``, 1, `expected a field name, found the end of the query`},
+	}
+
+	for _, tt := range tests {
+		_, err := ParseQuery(tt.query)
+		if err == nil {
+			t.Errorf("%s: expected an error", tt.query)
+			continue
+		}
+
+		qErr, ok := err.(*QueryError)
+		if !ok {
+			t.Errorf("%s: expected a *QueryError, got %T: %v", tt.query, err, err)
+			continue
+		}
+
+		if qErr.Pos+1 != tt.column || !strings.HasPrefix(qErr.Msg, tt.msg) {
+			t.Errorf("%s: expected %q at column %d, got %q at column %d", tt.query, tt.msg, tt.column, qErr.Msg, qErr.Pos+1)
+		}
+	}
+}
+
+func TestQueryErrorPointsAtColumn(t *testing.T) {
+	_, err := ParseQuery(`plays ! 3`)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	want := "column 7: expected != or !~\n  plays ! 3\n        ^"
+	if err.Error() != want {
+		t.Fatalf("expected:\n%s\ngot:\n%s", want, err.Error())
+	}
+}
@@ -0,0 +1,137 @@
+package songplayer
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+//maxSearchResults caps how many songs a search returns.
+const maxSearchResults = 200
+
+//editQueue applies a queue command. The queue holds full file names, and plays ahead of the batch.
+func (lib *SongLibrary) editQueue(cmd Command) error {
+	lib.mu.Lock()
+	defer lib.mu.Unlock()
+
+	//removing by position doesn't need a song to exist
+	if cmd.Signal == SignalDequeue && cmd.Target == "" {
+		if cmd.Value < 0 || int(cmd.Value) >= len(lib.Queue) {
+			return fmt.Errorf("no song queued at position %d", cmd.Value)
+		}
+
+		lib.Queue = append(lib.Queue[:cmd.Value], lib.Queue[cmd.Value+1:]...)
+		return nil
+	}
+
+	sF := lib.findSong(cmd.Target)
+	if sF == nil {
+		return fmt.Errorf("no song found matching [%s]", cmd.Target)
+	}
+
+	switch cmd.Signal {
+	case SignalEnqueue:
+		lib.Queue = append(lib.Queue, sF.FileName)
+	case SignalPlayNext:
+		lib.Queue = append([]string{sF.FileName}, lib.Queue...)
+	case SignalDequeue, SignalMoveQueued:
+		at := lib.queuedAt(sF.FileName)
+		if at < 0 {
+			return fmt.Errorf("%s isn't queued", path.Base(sF.FileName))
+		}
+
+		lib.Queue = append(lib.Queue[:at], lib.Queue[at+1:]...)
+
+		if cmd.Signal == SignalDequeue {
+			return nil
+		}
+
+		to := int(cmd.Value)
+		if to < 0 {
+			to = 0
+		} else if to > len(lib.Queue) {
+			to = len(lib.Queue)
+		}
+
+		lib.Queue = append(lib.Queue[:to], append([]string{sF.FileName}, lib.Queue[to:]...)...)
+	}
+
+	return nil
+}
+
+//queuedAt returns the position of a file in the queue, or -1 when it isn't queued.
+func (lib *SongLibrary) queuedAt(fileName string) int {
+	for i, q := range lib.Queue {
+		if q == fileName {
+			return i
+		}
+	}
+
+	return -1
+}
+
+//popQueue takes the next song off the queue, dropping any that are no longer in the library.
+//Returns nil when nothing is queued.
+func (lib *SongLibrary) popQueue() *SongFile {
+	lib.mu.Lock()
+	defer lib.mu.Unlock()
+
+	for len(lib.Queue) > 0 {
+		next := lib.Queue[0]
+		lib.Queue = lib.Queue[1:]
+
+		for i := range lib.Songs {
+			if lib.Songs[i].FileName == next {
+				return &lib.Songs[i]
+			}
+		}
+	}
+
+	return nil
+}
+
+//queued copies the file names of the queued songs, for the ui. They're full names, so the ui can
+//move a song it shows by its base name without mixing it up with another of the same name.
+func (lib *SongLibrary) queued() []string {
+	return append([]string(nil), lib.Queue...)
+}
+
+//Search lists the file names of songs whose path, title, artist or album contain every word of
+//text, ignoring case, so any song in the library can be picked for the queue.
+func (lib *SongLibrary) Search(text string) []string {
+	words := strings.Fields(strings.ToLower(text))
+
+	lib.mu.RLock()
+	defer lib.mu.RUnlock()
+
+	var found []string
+	for i := range lib.Songs {
+		sF := &lib.Songs[i]
+		about := strings.ToLower(strings.Join([]string{sF.FileName, sF.Tags.Title, sF.Tags.Artist, sF.Tags.Album}, "\n"))
+
+		matches := true
+		for _, w := range words {
+			if !strings.Contains(about, w) {
+				matches = false
+				break
+			}
+		}
+
+		if matches {
+			found = append(found, sF.FileName)
+		}
+	}
+
+	sort.Strings(found)
+	if len(found) > maxSearchResults {
+		found = found[:maxSearchResults]
+	}
+
+	return found
+}
+
+//SearchSongs searches the library the player is using.
+func SearchSongs(text string) []string {
+	return lib.Search(text)
+}
@@ -0,0 +1,247 @@
+package songplayer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSearch(t *testing.T) {
+	songs := testSongs(3)
+	songs[0].Tags.Title = "So What"
+	songs[1].Tags.Title = "Freddie Freeloader"
+	songs[2].Tags.Title = "Blue in Green"
+
+	l := &SongLibrary{Songs: songs}
+
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"so what", []string{songs[0].FileName}},
+		{"BLUE", []string{songs[2].FileName}},
+		{"album0 freddie", []string{songs[1].FileName}},
+		{"track3.mp3", []string{songs[2].FileName}},
+		{"", []string{songs[0].FileName, songs[1].FileName, songs[2].FileName}},
+		{"green freddie", nil},
+	}
+
+	for _, tt := range tests {
+		if got := l.Search(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: expected %v, got %v", tt.text, tt.want, got)
+		}
+	}
+
+	l.Songs = testSongs(maxSearchResults + 10)
+	if got := len(l.Search("test")); got != maxSearchResults {
+		t.Errorf("expected the results to be capped at %d, got %d", maxSearchResults, got)
+	}
+}
+
+func TestQueueSearchResult(t *testing.T) {
+	songs := testSongs(20)
+	l := &SongLibrary{Songs: songs}
+
+	//a song found by search is queued by its full file name, wherever it is in the library
+	found := l.Search("album1 track7")
+	if len(found) != 1 {
+		t.Fatalf("expected one song, got %v", found)
+	}
+
+	if err := l.editQueue(Command{Signal: SignalEnqueue, Target: found[0]}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := l.editQueue(Command{Signal: SignalPlayNext, Target: songs[3].FileName}); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{songs[3].FileName, found[0]}; !reflect.DeepEqual(l.Queue, want) {
+		t.Fatalf("expected the queue to be %v, got %v", want, l.Queue)
+	}
+
+	if sF := l.popQueue(); sF != &l.Songs[3] {
+		t.Fatalf("expected the song queued to play next to come off the queue first")
+	}
+}
+
+func TestMoveQueuedSongSharingBaseName(t *testing.T) {
+	//track1.mp3 of two albums, and another song between them
+	songs := testSongs(20)
+	l := &SongLibrary{Songs: songs}
+
+	for _, sF := range []SongFile{songs[0], songs[5], songs[10]} {
+		if err := l.editQueue(Command{Signal: SignalEnqueue, Target: sF.FileName}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//the ui moves the last song it shows to the front, by the name the player gave it
+	shown := l.queued()
+	if err := l.editQueue(Command{Signal: SignalMoveQueued, Target: shown[2], Value: 0}); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{songs[10].FileName, songs[0].FileName, songs[5].FileName}; !reflect.DeepEqual(l.Queue, want) {
+		t.Fatalf("expected the queue to be %v, got %v", want, l.Queue)
+	}
+}
+
+//queueAll applies each command in turn.
+func queueAll(t *testing.T, l *SongLibrary, cmds ...Command) {
+	t.Helper()
+
+	for _, cmd := range cmds {
+		if err := l.editQueue(cmd); err != nil {
+			t.Fatalf("%+v: %v", cmd, err)
+		}
+	}
+}
+
+func TestEditQueue(t *testing.T) {
+	songs := testSongs(20)
+	name := func(i int) string { return songs[i].FileName }
+
+	tests := []struct {
+		what string
+		cmds []Command
+		want []int
+	}{
+		{"enqueue", []Command{
+			{Signal: SignalEnqueue, Target: name(1)},
+			{Signal: SignalEnqueue, Target: name(2)},
+		}, []int{1, 2}},
+		{"play next", []Command{
+			{Signal: SignalEnqueue, Target: name(1)},
+			{Signal: SignalPlayNext, Target: name(2)},
+		}, []int{2, 1}},
+		{"the same song twice", []Command{
+			{Signal: SignalEnqueue, Target: name(1)},
+			{Signal: SignalEnqueue, Target: name(1)},
+		}, []int{1, 1}},
+		{"remove by position", []Command{
+			{Signal: SignalEnqueue, Target: name(1)},
+			{Signal: SignalEnqueue, Target: name(2)},
+			{Signal: SignalEnqueue, Target: name(3)},
+			{Signal: SignalDequeue, Value: 1},
+		}, []int{1, 3}},
+		{"remove by name", []Command{
+			{Signal: SignalEnqueue, Target: name(1)},
+			{Signal: SignalEnqueue, Target: name(2)},
+			{Signal: SignalDequeue, Target: name(1)},
+		}, []int{2}},
+		{"remove one of two songs with the same base name", []Command{
+			{Signal: SignalEnqueue, Target: name(0)},
+			{Signal: SignalEnqueue, Target: name(10)},
+			{Signal: SignalDequeue, Target: name(10)},
+		}, []int{0}},
+		{"move", []Command{
+			{Signal: SignalEnqueue, Target: name(1)},
+			{Signal: SignalEnqueue, Target: name(2)},
+			{Signal: SignalEnqueue, Target: name(3)},
+			{Signal: SignalMoveQueued, Target: name(1), Value: 2},
+		}, []int{2, 3, 1}},
+		{"move past either end", []Command{
+			{Signal: SignalEnqueue, Target: name(1)},
+			{Signal: SignalEnqueue, Target: name(2)},
+			{Signal: SignalEnqueue, Target: name(3)},
+			{Signal: SignalMoveQueued, Target: name(3), Value: -4},
+			{Signal: SignalMoveQueued, Target: name(2), Value: 9},
+		}, []int{3, 1, 2}},
+	}
+
+	for _, tt := range tests {
+		l := &SongLibrary{Songs: songs}
+		queueAll(t, l, tt.cmds...)
+
+		want := make([]string, len(tt.want))
+		for i, j := range tt.want {
+			want[i] = name(j)
+		}
+
+		if !reflect.DeepEqual(l.Queue, want) {
+			t.Errorf("%s: expected the queue to be %v, got %v", tt.what, want, l.Queue)
+		}
+	}
+
+	l := &SongLibrary{Songs: songs}
+	queueAll(t, l, Command{Signal: SignalEnqueue, Target: name(0)})
+
+	for _, cmd := range []Command{
+		{Signal: SignalEnqueue, Target: "/nowhere/song.mp3"},
+		{Signal: SignalDequeue, Value: 1},
+		{Signal: SignalDequeue, Value: -1},
+		{Signal: SignalDequeue, Target: name(10)},
+		{Signal: SignalMoveQueued, Target: name(10)},
+	} {
+		if err := l.editQueue(cmd); err == nil {
+			t.Errorf("%+v: expected an error", cmd)
+		}
+	}
+}
+
+func TestQueuePlaysBeforeBatch(t *testing.T) {
+	defer useLibrary(testSongs(20))()
+	SetPlaylistMaxSize(5)
+
+	lib.computeScores()
+	batch := batchNames(lib)
+
+	//songs are picked from the batch for a while, and the songs queued since come first
+	first := lib.nextSong().FileName
+	if first != batch[0] {
+		t.Fatalf("expected the batch to start with %s, got %s", batch[0], first)
+	}
+
+	queued := []string{batch[4], testSongs(20)[19].FileName, batch[4]}
+	for _, q := range queued {
+		queueAll(t, lib, Command{Signal: SignalEnqueue, Target: q})
+	}
+
+	var got []string
+	for i := 0; i < len(queued)+1; i++ {
+		got = append(got, lib.nextSong().FileName)
+	}
+
+	if want := append(queued, batch[1]); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected the queue then the rest of the batch\n%v\ngot\n%v", want, got)
+	}
+
+	if len(lib.Queue) != 0 || lib.NextSong != 2 {
+		t.Fatalf("expected the queue to be used up and the batch to move on by one, got %v at %d", lib.Queue, lib.NextSong)
+	}
+}
+
+func TestQueueIsSaved(t *testing.T) {
+	songs := testSongs(20)
+	defer useLibrary(songs)()
+	defer inTempDir(t)()
+
+	queueAll(t, lib,
+		Command{Signal: SignalEnqueue, Target: songs[0].FileName},
+		Command{Signal: SignalEnqueue, Target: songs[10].FileName},
+		Command{Signal: SignalPlayNext, Target: songs[3].FileName},
+	)
+	want := lib.queued()
+
+	lib.Version = cacheVersion
+	lib.persistSelf()
+
+	l, err := ReadLibrary("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(l.Queue, want) {
+		t.Fatalf("expected the queue %v to be read back, got %v", want, l.Queue)
+	}
+
+	//and still plays first, songs of the same base name included
+	var got []string
+	for range want {
+		got = append(got, l.popQueue().FileName)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected the saved queue to play in order\n%v\ngot\n%v", want, got)
+	}
+}
@@ -0,0 +1,79 @@
+package songplayer
+
+import (
+	"math"
+)
+
+var temperature float64
+
+//SetTemperature sets how loosely batches follow the score order. At 0 the highest ranked songs are
+//always played first. Higher temperatures sample songs with probability weighted by their rank,
+//approaching a pure random pick as the temperature grows. Temperatures are relative to the spread
+//of ranks in the library, so 1 means a song one standard deviation ahead is e times as likely to be
+//picked.
+func SetTemperature(t float64) {
+	if t < 0 {
+		t = 0
+	}
+
+	temperature = t
+}
+
+//drawPicks sets the key each song is ordered by when the batch is drawn. Sorting by rank plus
+//Gumbel noise is equivalent to weighted sampling without replacement, with each song weighted
+//by exp(rank / (temperature * stddev)). Callers must hold lib.mu and have seeded lib.rng.
+func (lib *SongLibrary) drawPicks() {
+	if temperature == 0 {
+		for i := range lib.Songs {
+			lib.Songs[i].pick = lib.Songs[i].rank
+			lib.Songs[i].Breakdown.Pick = lib.Songs[i].pick
+		}
+
+		return
+	}
+
+	scale := temperature * lib.rankStdDev()
+
+	for i := range lib.Songs {
+		lib.Songs[i].pick = lib.Songs[i].rank + scale*lib.gumbel()
+		lib.Songs[i].Breakdown.Pick = lib.Songs[i].pick
+	}
+}
+
+//gumbel draws from the standard Gumbel distribution.
+func (lib *SongLibrary) gumbel() float64 {
+	//1-Float64 keeps u in (0, 1], so the log is always defined
+	u := 1 - lib.rng.Float64()
+	g := -math.Log(-math.Log(u))
+	if math.IsInf(g, 0) {
+		return 0
+	}
+
+	return g
+}
+
+//rankStdDev is the standard deviation of the ranks of eligible songs, or 1 when they're all equal.
+func (lib *SongLibrary) rankStdDev() float64 {
+	var n, sum, sumSq float64
+
+	for i := range lib.Songs {
+		if !lib.Songs[i].eligible {
+			continue
+		}
+
+		n++
+		sum += lib.Songs[i].rank
+		sumSq += lib.Songs[i].rank * lib.Songs[i].rank
+	}
+
+	if n < 2 {
+		return 1
+	}
+
+	mean := sum / n
+	if v := sumSq/n - mean*mean; v > 0 {
+		return math.Sqrt(v)
+	}
+
+	return 1
+}
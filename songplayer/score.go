@@ -0,0 +1,181 @@
+package songplayer
+
+import (
+	"math"
+)
+
+//ScoreWeights tunes how far each factor moves a song's score when the library is computed.
+type ScoreWeights struct {
+	SkipPenalty    float64 `json:"skip_penalty"`    //Subtracted for every consecutive skip
+	PlayPenalty    float64 `json:"play_penalty"`    //Fraction of the average score dropped from over-played songs
+	StalenessBonus float64 `json:"staleness_bonus"` //Added per half-life that a song goes unheard
+	Jitter         float64 `json:"jitter"`          //Upper bound of the random noise added on every compute
+	HalfLifeHours  float64 `json:"half_life_hours"` //How long it takes a skip penalty to wear halfway off
+	OutroSeconds   float64 `json:"outro_seconds"`   //Skips this close to the end of a song count as plays
+	RatingWeight   float64 `json:"rating_weight"`   //Added per star above 3, taken per star below
+	FavouriteBoost float64 `json:"favourite_boost"` //Added to favourites
+	TimeContext    float64 `json:"time_context"`    //Most a song moves for being loved or skipped at times like now, 0 turns it off
+}
+
+//DefaultScoreWeights mirrors the weights the player shipped with before they were configurable.
+var DefaultScoreWeights = ScoreWeights{
+	SkipPenalty:    15,
+	PlayPenalty:    0.25,
+	StalenessBonus: 15,
+	Jitter:         5,
+	HalfLifeHours:  72,
+	OutroSeconds:   20,
+	RatingWeight:   10,
+	FavouriteBoost: 30,
+}
+
+var weights = DefaultScoreWeights
+
+//SetScoreWeights overrides the scoring weights. Weights are used as given, so 0 turns that part of
+//scoring off, except for the half-life, which falls back to its default unless it's above 0.
+func SetScoreWeights(w ScoreWeights) {
+	if w.HalfLifeHours <= 0 {
+		w.HalfLifeHours = DefaultScoreWeights.HalfLifeHours
+	}
+
+	weights = w
+}
+
+//halfLives converts the seconds between two unix times into half-lives.
+func halfLives(from, to int64) float64 {
+	if to <= from {
+		return 0
+	}
+
+	return float64(to-from) / (weights.HalfLifeHours * 3600)
+}
+
+//lastHeard is the last time the song was either played or skipped.
+func (pI *PlayInfo) lastHeard() int64 {
+	if pI.LastSkipped > pI.LastPlayed {
+		return pI.LastSkipped
+	}
+
+	return pI.LastPlayed
+}
+
+//skipWeight scales the last skip's penalty by how early it happened: bailing in the first seconds
+//costs the full penalty, bailing halfway through costs half.
+func (sF *SongFile) skipWeight() float64 {
+	if sF.PlayTime <= 0 || sF.LastSkipPosition <= 0 {
+		return 1
+	}
+
+	return math.Max(0, 1-float64(sF.LastSkipPosition)/float64(sF.PlayTime))
+}
+
+//computeSkipScore returns false if we should compute PlayScore
+func (sF *SongFile) computeSkipScore() bool {
+	pI := &sF.PlayInfo
+	b := &sF.Breakdown
+
+	//Compute the lastSkipped scores
+	if pI.LastSkipped > lib.LastCompute {
+		penalty := weights.SkipPenalty * float64(1+pI.ConsecutiveSkips)
+
+		if pI.TotalSkips > uint64(math.Floor(lib.AvgSkips)) {
+			penalty += weights.SkipPenalty
+		}
+
+		penalty *= sF.skipWeight()
+
+		pI.Score -= penalty
+		b.SkipPenalty = penalty
+		pI.SkipDebt += penalty
+		pI.ConsecutiveSkips++
+
+		return false
+	}
+
+	if pI.LastSkipped > pI.LastPlayed {
+		pI.ConsecutiveSkips = 0
+	}
+
+	//give the skipped songs a bit of attrition: outstanding penalties wear off by half every
+	//half-life, however many computes happen in between.
+	if pI.SkipDebt > 0 {
+		from := lib.LastCompute
+		if pI.LastSkipped > from {
+			from = pI.LastSkipped
+		}
+
+		recovered := pI.SkipDebt * (1 - math.Exp2(-halfLives(from, now().Unix())))
+		pI.SkipDebt -= recovered
+		pI.Score += recovered
+		b.SkipRecovery = recovered
+	}
+
+	return true
+}
+
+func (sF *SongFile) computePlayScore() {
+	pI := &sF.PlayInfo
+	b := &sF.Breakdown
+
+	//Songs grow staler the longer they go unheard. Only the time since the last compute is added,
+	//so the bonus adds up to the same amount no matter how often computes happen.
+	from := lib.LastCompute
+	if h := pI.lastHeard(); h > from {
+		from = h
+	}
+
+	if from > 0 {
+		b.Staleness = weights.StalenessBonus * halfLives(from, now().Unix())
+		pI.Score += b.Staleness
+	}
+
+	//We've just played the song, so we're going to drop its score.
+	//A negative average would turn the penalty into a bonus, so only a positive one counts.
+	justPlayed := pI.LastPlayed > lib.LastCompute
+	if justPlayed && lib.AvgScore > 0 && (pI.TotalPlays > lib.AvgPlays || pI.Score > lib.AvgScore) {
+		b.AvgAdjustment = -lib.AvgScore * weights.PlayPenalty
+		pI.Score += b.AvgAdjustment
+	}
+}
+
+func (sF *SongFile) computeScore() {
+	pI := &sF.PlayInfo
+
+	//every compute starts a fresh breakdown of where the score came from
+	sF.Breakdown = ScoreBreakdown{Computed: now().Unix(), Previous: pI.Score}
+	b := &sF.Breakdown
+	defer func() { b.Score = pI.Score }()
+
+	//give new songs some extra jitter.
+	if pI.Score == 0 {
+		//[0, numSongs)
+		b.NewSongJitter = float64(len(lib.Songs)) * lib.rng.Float64()
+		pI.Score += b.NewSongJitter
+	}
+
+	//[0, Jitter)
+	b.Jitter = weights.Jitter * lib.rng.Float64()
+	pI.Score += b.Jitter
+
+	if !sF.computeSkipScore() {
+		return
+	}
+
+	sF.computePlayScore()
+}
+
+//preference is how much the user's own opinion of the song moves it up or down the order. It isn't
+//folded into Score, so it doesn't pile up compute after compute.
+func (pI *PlayInfo) preference() float64 {
+	var p float64
+
+	if pI.Rating > 0 {
+		p += weights.RatingWeight * (float64(pI.Rating) - 3)
+	}
+
+	if pI.Favourite {
+		p += weights.FavouriteBoost
+	}
+
+	return p
+}
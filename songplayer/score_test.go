@@ -0,0 +1,37 @@
+package songplayer
+
+import (
+	"testing"
+)
+
+func TestZeroWeightsTurnScoringOff(t *testing.T) {
+	songs := testSongs(10)
+	for i := range songs {
+		songs[i].Score = 50
+		songs[i].Rating = 5
+		songs[i].Favourite = true
+		songs[i].TotalSkips = 3
+		songs[i].LastSkipped = 1
+	}
+
+	defer useLibrary(songs)()
+
+	SetScoreWeights(ScoreWeights{PlayPenalty: 0.25, StalenessBonus: 15, OutroSeconds: 20})
+
+	want := ScoreWeights{PlayPenalty: 0.25, StalenessBonus: 15, OutroSeconds: 20, HalfLifeHours: DefaultScoreWeights.HalfLifeHours}
+	if weights != want {
+		t.Fatalf("expected the weights to be kept as given, with the default half-life\n%+v\ngot\n%+v", want, weights)
+	}
+
+	lib.mu.Lock()
+	lib.seedCompute()
+	lib.compute()
+	lib.mu.Unlock()
+
+	for _, sF := range lib.Songs {
+		b := sF.Breakdown
+		if b.Jitter != 0 || b.SkipPenalty != 0 || b.Preference != 0 {
+			t.Fatalf("%s: expected no jitter, skip penalty or preference, got %+v", sF.FileName, b)
+		}
+	}
+}
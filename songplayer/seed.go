@@ -0,0 +1,177 @@
+package songplayer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"time"
+)
+
+const replayName = "songlib.replay"
+
+//replaySnapshot is the library exactly as a compute found it, alongside everything needed to run
+//that compute again.
+type replaySnapshot struct {
+	Seed        int64         `json:"seed"`
+	ComputeTime int64         `json:"compute_time"`
+	MaxSize     int           `json:"max_playlist_size"`
+	Config      *replayConfig `json:"config,omitempty"` //Config is missing from snapshots written before it was recorded
+	Library     *SongLibrary  `json:"library"`
+}
+
+//replayConfig is the configuration that shapes a compute.
+type replayConfig struct {
+	MusicDir     string         `json:"music_dir"`
+	Temperature  float64        `json:"temperature"`
+	Spread       SpreadGaps     `json:"spread"`
+	AlbumMode    bool           `json:"album_mode"`
+	Playlist     string         `json:"playlist,omitempty"` //Playlist is the rule of the active smart playlist
+	NoRepeat     NoRepeatWindow `json:"no_repeat"`
+	Discovery    Discovery      `json:"discovery"`
+	ScoreWeights ScoreWeights   `json:"score_weights"`
+	WeightRules  []WeightRule   `json:"weight_rules,omitempty"`
+}
+
+//currentConfig captures the configuration computes are running under.
+func currentConfig() *replayConfig {
+	cfg := &replayConfig{
+		MusicDir:     libDir,
+		Temperature:  temperature,
+		Spread:       gaps,
+		AlbumMode:    albumMode,
+		NoRepeat:     noRepeat,
+		Discovery:    discovery,
+		ScoreWeights: weights,
+		WeightRules:  weightRules,
+	}
+
+	if activePlaylist != nil {
+		cfg.Playlist = activePlaylist.String()
+	}
+
+	return cfg
+}
+
+//apply puts the configuration back in place, so a compute runs as it did when it was captured.
+func (cfg *replayConfig) apply() error {
+	if err := SetWeightRules(cfg.WeightRules); err != nil {
+		return err
+	}
+
+	activePlaylist = nil
+	if cfg.Playlist != "" {
+		q, err := ParseQuery(cfg.Playlist)
+		if err != nil {
+			return fmt.Errorf("playlist: %v", err)
+		}
+
+		activePlaylist = q
+	}
+
+	libDir = cfg.MusicDir
+	temperature = cfg.Temperature
+	gaps = cfg.Spread
+	albumMode = cfg.AlbumMode
+	noRepeat = cfg.NoRepeat
+	discovery = cfg.Discovery
+	weights = cfg.ScoreWeights
+
+	return nil
+}
+
+var (
+	//sessionRng hands out the seed of each compute. It's only seeded when a fixed seed is configured.
+	sessionRng *rand.Rand
+
+	//simulating keeps computes from logging and snapshotting while the simulator runs through them.
+	simulating bool
+)
+
+//SetSeed makes every compute of this session reproducible. Each compute still gets its own seed,
+//but the sequence of seeds is derived from this one. A seed of 0 leaves shuffling unseeded.
+func SetSeed(seed int64) {
+	if seed == 0 {
+		sessionRng = nil
+		return
+	}
+
+	sessionRng = rand.New(rand.NewSource(seed))
+}
+
+func nextSeed() int64 {
+	if sessionRng == nil {
+		return time.Now().UnixNano()
+	}
+
+	return sessionRng.Int63()
+}
+
+//seedCompute picks the seed for the compute that's about to happen, logs it and snapshots the
+//library so that compute can be replayed later.
+func (lib *SongLibrary) seedCompute() {
+	lib.Seed = nextSeed()
+	lib.rng = rand.New(rand.NewSource(lib.Seed))
+
+	if simulating {
+		return
+	}
+
+	fmt.Printf("computing scores with seed [%d]\n", lib.Seed)
+
+	res, err := json.Marshal(replaySnapshot{
+		Seed:        lib.Seed,
+		ComputeTime: now().Unix(),
+		MaxSize:     maxSize,
+		Config:      currentConfig(),
+		Library:     lib,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	if err := ioutil.WriteFile(replayName, res, 0666); err != nil {
+		fmt.Println("unable to write replay snapshot: " + err.Error())
+	}
+}
+
+//Replay loads a snapshot written by a previous compute and runs that compute again with the same
+//seed, clock and configuration, returning the library in the order the compute produced. When
+//snapshotFile is empty, the snapshot of the most recent compute is used. Snapshots that predate the
+//configuration being recorded replay under the current configuration.
+func Replay(snapshotFile string) (*SongLibrary, error) {
+	if snapshotFile == "" {
+		snapshotFile = replayName
+	}
+
+	res, err := ioutil.ReadFile(snapshotFile)
+	if err != nil {
+		return nil, err
+	}
+
+	snap := replaySnapshot{Library: &SongLibrary{}}
+	if err = json.Unmarshal(res, &snap); err != nil {
+		return nil, err
+	}
+
+	if snap.Config != nil {
+		if err = snap.Config.apply(); err != nil {
+			return nil, fmt.Errorf("replaying the snapshot's config: %v", err)
+		}
+	}
+
+	lib = snap.Library
+	maxSize = snap.MaxSize
+
+	fixed := time.Unix(snap.ComputeTime, 0)
+	now = func() time.Time { return fixed }
+	defer func() { now = time.Now }()
+
+	lib.mu.Lock()
+	lib.rng = rand.New(rand.NewSource(snap.Seed))
+	lib.Seed = snap.Seed
+	lib.compute()
+	lib.mu.Unlock()
+
+	return lib, nil
+}
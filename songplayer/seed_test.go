@@ -0,0 +1,119 @@
+package songplayer
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+//resetConfig puts every setting a compute depends on back to how the package starts out.
+func resetConfig() {
+	libDir = ""
+	SetTemperature(0)
+	SetSpreadGaps(SpreadGaps{})
+	SetAlbumMode(false)
+	SetNoRepeatWindow(NoRepeatWindow{})
+	SetDiscovery(Discovery{})
+	weights = DefaultScoreWeights
+	_ = SetWeightRules(nil)
+	_ = UsePlaylist("")
+}
+
+func batchNames(l *SongLibrary) []string {
+	var names []string
+	for _, sF := range l.Batch() {
+		names = append(names, sF.FileName)
+	}
+
+	return names
+}
+
+//inTempDir moves into a temporary working directory, where the library cache and snapshots are
+//written. Returns a func moving back and removing it.
+func inTempDir(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "songplayer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	return func() {
+		_ = os.Chdir(wd)
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func TestReplayUsesSnapshotConfig(t *testing.T) {
+	songs := testSongs(120)
+	for i := range songs {
+		songs[i].Score = float64(i % 7)
+		songs[i].TotalPlays = uint64(i % 3)
+	}
+
+	defer useLibrary(songs)()
+	defer resetConfig()
+
+	//the snapshot is written to the working directory
+	defer inTempDir(t)()
+
+	libDir = "/test"
+	SetTemperature(0.5)
+	SetSpreadGaps(SpreadGaps{Artist: 1, Album: 2})
+	SetNoRepeatWindow(NoRepeatWindow{Songs: 5})
+	SetDiscovery(Discovery{Ratio: 0.2})
+	weights.Jitter = 20
+	weights.RatingWeight = 0
+
+	if err := SetWeightRules([]WeightRule{{Dir: "artist1", Multiplier: 3, From: "01-01", To: "06-30"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SetSmartPlaylists(map[string]string{"most": `artist != "artist2"`}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := UsePlaylist("most"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := *currentConfig()
+
+	at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return at }
+
+	//compute as the player does, which writes the snapshot
+	simulating = false
+	lib.mu.Lock()
+	lib.seedCompute()
+	lib.compute()
+	lib.mu.Unlock()
+	simulating = true
+
+	batch := batchNames(lib)
+
+	//replaying under the defaults still runs the compute the way it first ran
+	resetConfig()
+
+	replayed, err := Replay("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := batchNames(replayed); !reflect.DeepEqual(got, batch) {
+		t.Fatalf("expected the replay to produce the batch\n%v\ngot\n%v", batch, got)
+	}
+
+	if got := *currentConfig(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected the replay to apply the config\n%+v\ngot\n%+v", want, got)
+	}
+}
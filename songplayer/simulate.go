@@ -0,0 +1,208 @@
+package songplayer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+//SimConfig describes a simulated run of listening sessions.
+type SimConfig struct {
+	Library      string        //Library is a cache file to simulate against. A synthetic library is built when empty
+	Songs        int           //Songs is the size of the synthetic library
+	Sessions     int           //Sessions is the number of listening sessions to run
+	SessionSongs int           //SessionSongs is how many songs are heard per session
+	SessionGap   time.Duration //SessionGap is the time between the end of one session and the start of the next
+	SkipRate     float64       //SkipRate is the average chance a song gets skipped
+	Seed         int64         //Seed drives both the shuffle and the skip model
+}
+
+//SimReport summarises how fairly a simulated run spread its plays across the library.
+type SimReport struct {
+	LibrarySize int
+	Heard       int //Heard is every song served, played or skipped
+	Skipped     int
+
+	//Repeat intervals count the songs served between two servings of the same song.
+	Repeats              int
+	MinRepeatInterval    int
+	MedianRepeatInterval float64
+	MeanRepeatInterval   float64
+
+	//Coverage is the fraction of the library heard at least once by the end of each session.
+	Coverage []float64
+
+	//Gini is the Gini coefficient of play counts over the run: 0 when every song was played
+	//equally often, approaching 1 when a few songs took every play.
+	Gini float64
+}
+
+//Simulate runs the real selection and scoring path through cfg.Sessions listening sessions on a
+//fake clock, with a skip model standing in for the listener. Nothing is played or persisted.
+func Simulate(cfg SimConfig) (SimReport, error) {
+	simLib, err := simLibrary(cfg)
+	if err != nil {
+		return SimReport{}, err
+	}
+
+	if len(simLib.Songs) == 0 {
+		return SimReport{}, fmt.Errorf("can't simulate an empty library")
+	}
+
+	prevLib, prevMax, prevNow, prevSession := lib, maxSize, now, sessionRng
+	defer func() {
+		lib, maxSize, now, sessionRng, simulating = prevLib, prevMax, prevNow, prevSession, false
+	}()
+
+	lib = simLib
+	simulating = true
+
+	if maxSize > len(lib.Songs) {
+		maxSize = len(lib.Songs)
+	}
+
+	clock := time.Now()
+	now = func() time.Time { return clock }
+
+	SetSeed(cfg.Seed)
+	listener := rand.New(rand.NewSource(cfg.Seed))
+
+	//every song gets a fixed appeal, so the same songs keep getting skipped
+	appeal := make(map[string]float64, len(lib.Songs))
+	for _, sF := range lib.Songs {
+		appeal[sF.FileName] = listener.Float64()
+	}
+
+	report := SimReport{LibrarySize: len(lib.Songs), MinRepeatInterval: math.MaxInt32}
+	lastHeard := make(map[string]int, len(lib.Songs))
+	plays := make(map[string]int, len(lib.Songs))
+	var intervals []int
+
+	for session := 0; session < cfg.Sessions; session++ {
+		for i := 0; i < cfg.SessionSongs; i++ {
+			sF := lib.nextSong()
+			if sF == nil {
+				return SimReport{}, fmt.Errorf("every song in the library is banned or excluded from play")
+			}
+
+			if at, ok := lastHeard[sF.FileName]; ok {
+				intervals = append(intervals, report.Heard-at)
+			}
+			lastHeard[sF.FileName] = report.Heard
+			report.Heard++
+
+			start := clock
+			skipped := listener.Float64() < 2*cfg.SkipRate*(1-appeal[sF.FileName])
+
+			var skippedAt time.Duration
+			if skipped {
+				report.Skipped++
+				skippedAt = time.Duration(listener.Int63n(int64(sF.PlayTime)))
+				clock = clock.Add(skippedAt)
+			} else {
+				plays[sF.FileName]++
+				clock = clock.Add(sF.PlayTime)
+			}
+
+			lib.mu.Lock()
+			sF.update(start, skipped, skippedAt)
+			lib.mu.Unlock()
+		}
+
+		report.Coverage = append(report.Coverage, float64(len(lastHeard))/float64(len(lib.Songs)))
+		clock = clock.Add(cfg.SessionGap)
+	}
+
+	report.Repeats = len(intervals)
+	if len(intervals) > 0 {
+		sort.Ints(intervals)
+
+		var sum int
+		for _, iv := range intervals {
+			sum += iv
+		}
+
+		report.MinRepeatInterval = intervals[0]
+		report.MeanRepeatInterval = float64(sum) / float64(len(intervals))
+		report.MedianRepeatInterval = float64(intervals[len(intervals)/2])
+		if len(intervals)%2 == 0 {
+			report.MedianRepeatInterval = float64(intervals[len(intervals)/2-1]+intervals[len(intervals)/2]) / 2
+		}
+	} else {
+		report.MinRepeatInterval = 0
+	}
+
+	counts := make([]int, 0, len(lib.Songs))
+	for _, sF := range lib.Songs {
+		counts = append(counts, plays[sF.FileName])
+	}
+	report.Gini = gini(counts)
+
+	return report, nil
+}
+
+//simLibrary loads a copy of a cached library, or builds a synthetic one of cfg.Songs songs spread
+//over artists and albums.
+func simLibrary(cfg SimConfig) (*SongLibrary, error) {
+	simLib := &SongLibrary{}
+
+	if cfg.Library != "" {
+		res, err := ioutil.ReadFile(cfg.Library)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = json.Unmarshal(res, simLib); err != nil {
+			return nil, err
+		}
+
+		//start from a fresh batch, like a restart would. Nothing is played, so missing or corrupt
+		//files don't matter.
+		simLib.BatchSize = 0
+		for i := range simLib.Songs {
+			simLib.Songs[i].Unavailable = false
+			simLib.Songs[i].Undecodable = 0
+		}
+
+		return simLib, nil
+	}
+
+	gen := rand.New(rand.NewSource(cfg.Seed))
+
+	for i := 0; i < cfg.Songs; i++ {
+		artist, album := i/40, i/10
+		simLib.Songs = append(simLib.Songs, SongFile{
+			FileName: fmt.Sprintf("/simulated/artist%d/album%d/track%d.mp3", artist, album, i%10+1),
+			PlayTime: 90*time.Second + time.Duration(gen.Int63n(int64(5*time.Minute))),
+			Tags: Tags{
+				Artist: fmt.Sprintf("artist%d", artist),
+				Album:  fmt.Sprintf("album%d", album),
+				Track:  i%10 + 1,
+			},
+		})
+	}
+
+	return simLib, nil
+}
+
+//gini computes the Gini coefficient of a set of counts.
+func gini(counts []int) float64 {
+	sort.Ints(counts)
+
+	var total, weighted float64
+	for i, c := range counts {
+		total += float64(c)
+		weighted += float64(i+1) * float64(c)
+	}
+
+	n := float64(len(counts))
+	if total == 0 || n == 0 {
+		return 0
+	}
+
+	return 2*weighted/(n*total) - (n+1)/n
+}
@@ -0,0 +1,47 @@
+package songplayer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSimulateRunsToCompletion(t *testing.T) {
+	SetScoreWeights(DefaultScoreWeights)
+
+	cfg := SimConfig{
+		Songs:        200,
+		Sessions:     5,
+		SessionSongs: 30,
+		SessionGap:   8 * time.Hour,
+		SkipRate:     0.2,
+		Seed:         1,
+	}
+
+	type result struct {
+		report SimReport
+		err    error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		report, err := Simulate(cfg)
+		done <- result{report, err}
+	}()
+
+	select {
+	case res := <-done:
+		if res.err != nil {
+			t.Fatal("simulate failed: ", res.err)
+		}
+
+		if res.report.Heard != cfg.Sessions*cfg.SessionSongs {
+			t.Fatalf("expected %d songs heard, got %d", cfg.Sessions*cfg.SessionSongs, res.report.Heard)
+		}
+
+		if len(res.report.Coverage) != cfg.Sessions {
+			t.Fatalf("expected coverage for %d sessions, got %d", cfg.Sessions, len(res.report.Coverage))
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatal("simulate didn't finish")
+	}
+}
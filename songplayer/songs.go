@@ -2,8 +2,6 @@ package songplayer
 
 import (
 	"fmt"
-	"math"
-	"math/rand"
 	"os"
 	"path"
 	"sync"
@@ -12,30 +10,48 @@ import (
 
 	"github.com/faiface/beep"
 	"github.com/faiface/beep/mp3"
-	"github.com/faiface/beep/speaker"
 )
 
 type (
 	PlayInfo struct {
-		Score             uint64 `json:"score,omitempty"`
-		TotalSkips        uint64 `json:"total_skips,omitempty"`
-		ConsecutiveSkips  uint   `json:"consecutive_skips,omitempty"`
-		LastSkipped       int64  `json:"last_skipped_time,omitempty"`
-		LastPlayed        int64  `json:"last_played_time,omitempty"`
-		TotalPlays        uint64 `json:"total_plays,omitempty"`
-		ComputesSincePlay uint8  `json:"computes_since_last_play,omitempty"`
+		Score            float64       `json:"score,omitempty"`
+		TotalSkips       uint64        `json:"total_skips,omitempty"`
+		ConsecutiveSkips uint          `json:"consecutive_skips,omitempty"`
+		LastSkipped      int64         `json:"last_skipped_time,omitempty"`
+		LastPlayed       int64         `json:"last_played_time,omitempty"`
+		TotalPlays       uint64        `json:"total_plays,omitempty"`
+		SkipDebt         float64       `json:"skip_penalty_outstanding,omitempty"`
+		LastSkipPosition time.Duration `json:"last_skip_position,omitempty"`
+		Rating           uint8         `json:"rating,omitempty"` //1-5 stars, 0 when unrated
+		Favourite        bool          `json:"favourite,omitempty"`
+		Banned           bool          `json:"never_play,omitempty"`
+		TimeContext
 	}
 	SongFile struct {
 		FileName    string        `json:"file_name,omitempty"`
 		PlayTime    time.Duration `json:"play_time,omitempty"`
+		Tags        Tags          `json:"tags"`
 		playingSong PlayingSong
+		rank        float64        //rank is the song's score with preferences included
+		pick        float64        //pick is the key the batch is drawn by: rank, loosened by the temperature
+		eligible    bool           //eligible is false for songs the latest compute kept out of the batch
+		Breakdown   ScoreBreakdown `json:"breakdown"`
+		Unavailable bool           `json:"unavailable,omitempty"` //Unavailable is set while the song's file is missing
+		Undecodable int64          `json:"undecodable,omitempty"` //Undecodable is when the file was last modified, if it couldn't be decoded
+		Loudness    *Loudness      `json:"loudness,omitempty"`
 		PlayInfo
 	}
 	PlayingSong struct {
 		SongTime    time.Duration
-		SongScore   uint64
+		SongScore   float64
 		SongLength  time.Duration
 		CurrentSong string
+		Rating      uint8
+		Favourite   bool
+		Banned      bool
+		Queue       []string
+		Volume      int
+		Muted       bool
 	}
 )
 
@@ -45,6 +61,22 @@ const (
 	SignalSkip
 	SignalExit
 	SignalSongComplete
+	SignalRate
+	SignalFavourite
+	SignalBan
+	SignalEnqueue
+	SignalPlayNext
+	SignalDequeue
+	SignalMoveQueued
+	SignalExplain
+	SignalSeek      //SignalSeek moves playback Value milliseconds forward, or back when negative
+	SignalSeekTo    //SignalSeekTo moves playback to Value milliseconds into the song
+	SignalVolume    //SignalVolume turns the volume up Value percent, or down when negative
+	SignalSetVolume //SignalSetVolume sets the volume to Value percent
+	SignalMute      //SignalMute mutes the player when Value is 1, and unmutes it when 0
+	SignalPrevious  //SignalPrevious goes back to the last song heard, or the start of this one
+	SignalRestart   //SignalRestart plays the current song again from the start
+	SignalSearch    //SignalSearch asks for the songs matching Target, answered on the connection that asked
 )
 
 //Cross-goroutine helpers
@@ -52,54 +84,43 @@ var (
 	SongState = make(chan PlayingSong)
 
 	//PlayerSignal signals input state from the ui to the player
-	PlayerSignal = make(chan int64)
+	PlayerSignal = make(chan Command)
+
+	//nowPlaying holds the file name of the song being played.
+	nowPlaying atomic.Value
 
 	//playMu is for ensuring only one song is playing
 	playMu sync.Mutex
 	//Concurrency-safe containers for playback crosstalk.
 	playStart  atomic.Value
 	timePaused atomic.Value
-	format     beep.Format
 )
 
-func (sF *SongFile) initFile() (s beep.StreamSeeker) {
-	//Load the song file
-	f, err := os.Open(sF.FileName)
-	if err != nil {
-		panic(err)
-	}
-
-	//load beep's StreamSeeker
-	if s, format, err = mp3.Decode(f); err != nil {
-		panic(err)
-	}
-
-	//Any higher precision than this ends with the songs playing much faster than intended, for some reason.
-	//Not particularly inclined to debug the intricacies of Beep and its child packages.
-	snr := format.SampleRate.N(time.Second / 2)
-
-	//Rather than muck about with funky math for different song sample rates,
-	//let's just initialize it to the exact format every time - we're only gonna
-	//be playing one song at a time, anyway.
-	if err = speaker.Init(format.SampleRate, snr); err != nil {
-		panic(err)
-	}
-
-	//This seems to decrease performance quite a bit, leaving it commented out for now.
-	//buf := beep.NewBuffer(format)
-	//buf.Append(s)
-	//s = buf.Streamer(0, buf.Len())
-
-	return s
-}
-
 //Play locks the current goroutine/thread until an interrupt
 func (sF *SongFile) play() (shouldExit bool) {
 	playMu.Lock()
 
 	fmt.Println("initializing song file")
 
-	s := sF.initFile()
+	t, err := player.open(sF)
+	if err != nil {
+		playMu.Unlock()
+
+		//the drive may have gone away since the last compute, or the file may be corrupt. Move on
+		//without counting it as heard.
+		if _, ok := err.(*decodeError); ok {
+			sF.markUndecodable(err)
+		} else if os.IsNotExist(err) {
+			sF.markUnavailable(err)
+		} else {
+			fmt.Printf("unable to play %s: %v\n", sF.FileName, err)
+		}
+
+		return false
+	}
+
+	name := sF.FileName
+	nowPlaying.Store(name)
 
 	//Signal to the ui what's playing. Perhaps an atomic.Value would be better?
 	sF.playingSong = PlayingSong{
@@ -107,6 +128,7 @@ func (sF *SongFile) play() (shouldExit bool) {
 		SongLength:  sF.PlayTime,
 		SongScore:   sF.Score,
 	}
+	sF.refreshState()
 
 	fmt.Println("sending song to client: " + sF.playingSong.CurrentSong)
 
@@ -114,51 +136,74 @@ func (sF *SongFile) play() (shouldExit bool) {
 
 	fmt.Println("song file initialized, initializing player")
 
-	var skipped atomic.Value
-	skipped.Store(false)
-
-	//So we can pause songs
-	ctrl := &beep.Ctrl{
-		Paused: false,
-		Streamer: beep.Seq(s, beep.Callback(func() {
-			wasSkipped := skipped.Load().(bool)
-			if wasSkipped {
-				return
-			}
-
-			PlayerSignal <- SignalSongComplete
-		})),
-	}
-
 	//fmt.Println("initiating play for song: " + sF.FileName)
 
 	timePaused.Store(time.Time{})
-	//So we know when the song started.
-	playStart.Store(time.Now())
 
-	speaker.Play(ctrl)
+	//When the last song ran straight into this one, it's already playing
+	if err = player.start(t); err != nil {
+		panic(err)
+	}
+
+	//So we know when the song started. With a crossfade, that's before the last one finished.
+	output.Lock()
+	playStart.Store(t.started)
+	output.Unlock()
 
 	var plyrSig int64
+	var skippedAt time.Duration
+	primed := false
 	tkr := time.NewTicker(75 * time.Millisecond)
 
 	for {
 		select {
 		case <-tkr.C:
-			sF.playingSong.SongTime = format.SampleRate.D(s.Position())
+			output.Lock()
+			pos := t.position()
+			output.Unlock()
+
+			sF = sF.relocate(name)
+			sF.playingSong.SongTime = pos
+			sF.refreshState()
 			SongState <- sF.playingSong
-		case plyrSig = <-PlayerSignal:
-			//Signal the exit here, which will cause the done func up above to trigger and send
-			//the signalComplete signal. Hopefully, out of order event reception doesn't happen super often
+
+			//line the next song up while there's still time to open it
+			if !primed && sF.PlayTime-pos <= primeAhead+crossfade {
+				primed = true
+				player.startPriming()
+			}
+		case done := <-trackDone:
+			//the deck may report a song it finished before this one started
+			if done == name {
+				goto closeShop
+			}
+		case cmd := <-PlayerSignal:
+			plyrSig = cmd.Signal
 			switch plyrSig {
 			case SignalSkip:
-				speaker.Clear()
+				skippedAt = player.skip(t)
 				goto closeShop
 			case SignalExit:
 				shouldExit = true
-				skipped.Store(false)
 			case SignalPause, SignalPlay:
-				sF.togglePause(ctrl)
-			case SignalSongComplete:
+				sF.togglePause(player.ctrl)
+			case SignalSeek:
+				player.seek(t, func(pos time.Duration) time.Duration { return pos + time.Duration(cmd.Value)*time.Millisecond })
+			case SignalSeekTo:
+				player.seek(t, func(time.Duration) time.Duration { return time.Duration(cmd.Value) * time.Millisecond })
+			case SignalRestart:
+				player.seek(t, func(time.Duration) time.Duration { return 0 })
+			case SignalPrevious:
+				//with nothing to go back to, going back is starting over
+				if !lib.canRewind() {
+					player.seek(t, func(time.Duration) time.Duration { return 0 })
+					break
+				}
+
+				//the song lined up next goes back in the queue, after this one
+				primed := player.unprime()
+				player.stop(t)
+				lib.rewind(name, primed)
 				goto closeShop
 			}
 			plyrSig = 0
@@ -168,9 +213,12 @@ func (sF *SongFile) play() (shouldExit bool) {
 closeShop:
 	tkr.Stop()
 	playMu.Unlock()
-	skpd := plyrSig == SignalSkip
-	skipped.Store(skpd)
-	sF.onFinish(ctrl, skpd)
+	sF = sF.relocate(name)
+
+	//going back leaves the song to be heard again, so it doesn't count as played or skipped
+	if plyrSig != SignalPrevious {
+		sF.onFinish(player.ctrl, t, plyrSig == SignalSkip, skippedAt)
+	}
 	return
 }
 
@@ -185,22 +233,31 @@ func (sF *SongFile) togglePause(ctrl *beep.Ctrl) {
 		timePaused.Store(pAt)
 	}
 
-	speaker.Lock()
+	output.Lock()
 	ctrl.Paused = !ctrl.Paused
-	speaker.Unlock()
+	output.Unlock()
 }
 
-func (sF *SongFile) onFinish(ctrl *beep.Ctrl, skipped bool) {
-	speaker.Lock()
-	ps := playStart.Load().(time.Time)
+func (sF *SongFile) onFinish(ctrl *beep.Ctrl, t *track, skipped bool, skippedAt time.Duration) {
+	output.Lock()
+	//the song's time stops when it's paused, or when the next song starts fading in over it, as the
+	//crossfade is counted in the next song's time instead
+	end := time.Now()
 	if ctrl.Paused {
-		ps = ps.Add(time.Since(timePaused.Load().(time.Time)))
+		end = timePaused.Load().(time.Time)
 	}
-	speaker.Unlock()
+
+	if !t.fadedOut.IsZero() && t.fadedOut.Before(end) {
+		end = t.fadedOut
+	}
+
+	ps := playStart.Load().(time.Time).Add(time.Since(end))
+	output.Unlock()
 
 	lib.mu.Lock()
 
-	sF.update(ps, skipped)
+	lib.recordHistory(sF, skipped)
+	sF.update(ps, skipped, skippedAt)
 
 	lib.mu.Unlock()
 
@@ -210,7 +267,7 @@ func (sF *SongFile) onFinish(ctrl *beep.Ctrl, skipped bool) {
 func (sF *SongFile) loadPlayTime() error {
 	f, err := os.Open(sF.FileName)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	streamer, _fmt, err := mp3.Decode(f)
@@ -223,74 +280,27 @@ func (sF *SongFile) loadPlayTime() error {
 	return nil
 }
 
-func (sF *SongFile) update(s time.Time, skipped bool) {
+//update records the song being heard. Skips that land in the song's outro count as plays.
+func (sF *SongFile) update(s time.Time, skipped bool, skippedAt time.Duration) {
+	if skipped && sF.PlayTime-skippedAt <= time.Duration(weights.OutroSeconds*float64(time.Second)) {
+		skipped = false
+	}
+
+	sF.TimeContext.record(now(), skipped)
+
 	if skipped {
 		sF.ConsecutiveSkips++
 		sF.TotalSkips++
-		sF.ComputesSincePlay = 0
-		sF.LastSkipped = time.Now().Unix()
+		sF.LastSkipped = now().Unix()
+		sF.LastSkipPosition = skippedAt
 		lib.NumSkips++
-		lib.TimePlayed += time.Since(s)
+		lib.TimePlayed += now().Sub(s)
 		return
 	}
 	sF.TotalPlays++
-	sF.LastPlayed = time.Now().Unix()
+	sF.LastPlayed = now().Unix()
 	sF.ConsecutiveSkips = 0
-	sF.ComputesSincePlay = 0
 	lib.NumPlays++
-	lib.TimePlayed += time.Since(s)
-
-}
-
-//computeSkipScore returns false if we should compute PlayScore
-func (pI *PlayInfo) computeSkipScore() bool {
-	//Compute the lastSkipped scores
-	if pI.LastSkipped > lib.LastCompute {
-		pI.Score -= 15 * uint64(1+pI.ConsecutiveSkips)
-
-		if pI.TotalSkips > uint64(math.Floor(lib.AvgSkips)) {
-			pI.Score -= 15
-		}
-
-		pI.ConsecutiveSkips++
-
-		return false
-	}
-
-	if pI.LastSkipped > pI.LastPlayed {
-		pI.ConsecutiveSkips = 0
-	}
-
-	//give the skipped songs a bit of attrition
-	pI.Score += 1
-	return true
-}
-
-func (pI *PlayInfo) computePlayScore() {
-	if pI.LastPlayed < lib.LastCompute {
-		pI.ComputesSincePlay++
-		pI.Score += 15 * uint64(pI.ComputesSincePlay)
-	}
-
-	//We've just played the song, so we're going to drop its score.
-	if pI.TotalPlays > lib.AvgPlays || pI.Score > lib.AvgScore {
-		pI.Score -= lib.AvgScore / 4
-	}
-}
-
-func (pI *PlayInfo) computeScore() {
-	//give new songs some extra jitter.
-	if pI.Score == 0 {
-		//[0, numSongs]
-		pI.Score += uint64(math.Floor(float64(len(lib.Songs)) * rand.Float64()))
-	}
-
-	//[0, 5]
-	pI.Score += uint64(math.Floor(5 * rand.Float64()))
-
-	if !pI.computeSkipScore() {
-		return
-	}
+	lib.TimePlayed += now().Sub(s)
 
-	pI.computePlayScore()
 }
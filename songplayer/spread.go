@@ -0,0 +1,123 @@
+package songplayer
+
+//SpreadGaps are the minimum number of songs that must play between two songs sharing an artist,
+//album or directory. A gap of 0 disables that constraint.
+type SpreadGaps struct {
+	Artist    int `json:"artist"`
+	Album     int `json:"album"`
+	Directory int `json:"directory"`
+}
+
+var gaps SpreadGaps
+
+//SetSpreadGaps configures how far apart related songs are kept within a batch.
+func SetSpreadGaps(g SpreadGaps) {
+	gaps = g
+}
+
+//spreadKeys caches the values a song is compared on, so they aren't rebuilt on every comparison.
+type spreadKeys struct {
+	artist, album, dir string
+}
+
+func keysOf(sF *SongFile) spreadKeys {
+	return spreadKeys{artist: sF.Artist(), album: sF.Album(), dir: sF.Dir()}
+}
+
+//conflicts reports whether cand can't be placed at idx of batch, with each gap divided by relax.
+func (g SpreadGaps) conflicts(batch []spreadKeys, idx int, cand spreadKeys, relax int) bool {
+	check := func(gap int, same func(o spreadKeys) bool) bool {
+		gap /= relax
+		for j := idx - 1; j >= 0 && j >= idx-gap; j-- {
+			if same(batch[j]) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return check(g.Artist, func(o spreadKeys) bool { return cand.artist != "" && o.artist == cand.artist }) ||
+		check(g.Album, func(o spreadKeys) bool { return o.album == cand.album }) ||
+		check(g.Directory, func(o spreadKeys) bool { return o.dir == cand.dir })
+}
+
+//spreadBatch walks the upcoming batch in score order, and whenever a song lands too close to a
+//related one, pulls the best scoring song that fits into its place. Replacements only come from the
+//first poolSize songs. When nothing in the pool fits, the gaps are halved until something does, so
+//small libraries still play in score order. Songs pulled in from beyond the batch never push out
+//the undiscovered songs the batch needs to keep its discovery ratio.
+func (lib *SongLibrary) spreadBatch(batchSize, poolSize int) {
+	if gaps == (SpreadGaps{}) {
+		return
+	}
+
+	n := poolSize
+	if n > len(lib.Songs) {
+		n = len(lib.Songs)
+	}
+
+	if batchSize > n {
+		batchSize = n
+	}
+
+	keys := make([]spreadKeys, n)
+	for i := range keys {
+		keys[i] = keysOf(&lib.Songs[i])
+	}
+
+	//the batch holds on to as many undiscovered songs as the ratio asks for
+	var have, need int
+	if discovery.Ratio > 0 {
+		need = int(float64(batchSize) * discovery.Ratio)
+		for i := 0; i < batchSize; i++ {
+			if lib.Songs[i].undiscovered() {
+				have++
+			}
+		}
+	}
+
+	//fits reports whether song k can be pulled into place at i. Pulling in a song from beyond the
+	//batch pushes the last song of the batch out.
+	fits := func(i, k, relax int) bool {
+		if gaps.conflicts(keys, i, keys[k], relax) {
+			return false
+		}
+
+		if discovery.Ratio == 0 || k < batchSize || !lib.Songs[batchSize-1].undiscovered() || lib.Songs[k].undiscovered() {
+			return true
+		}
+
+		return have > need
+	}
+
+	for i := 1; i < batchSize; i++ {
+		for relax := 1; ; relax *= 2 {
+			if !gaps.conflicts(keys, i, keys[i], relax) {
+				break
+			}
+
+			k := i + 1
+			for ; k < n && !fits(i, k, relax); k++ {
+			}
+
+			if k < n {
+				//keep count of the undiscovered songs entering and leaving the batch
+				if k >= batchSize && lib.Songs[batchSize-1].undiscovered() != lib.Songs[k].undiscovered() {
+					if lib.Songs[k].undiscovered() {
+						have++
+					} else {
+						have--
+					}
+				}
+
+				//rotate the fitting song into place, leaving the ones it jumped in score order
+				song, key := lib.Songs[k], keys[k]
+				copy(lib.Songs[i+1:k+1], lib.Songs[i:k])
+				copy(keys[i+1:k+1], keys[i:k])
+				lib.Songs[i], keys[i] = song, key
+				break
+			}
+		}
+	}
+}
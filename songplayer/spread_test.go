@@ -0,0 +1,82 @@
+package songplayer
+
+import (
+	"testing"
+)
+
+//spreadSongs lines up a batch of five songs by one artist, the last two undiscovered, ahead of
+//songs by other artists.
+func spreadSongs(rest ...string) []SongFile {
+	songs := testSongs(5 + len(rest))
+	for i := range songs {
+		songs[i].Tags.Artist = "a"
+		songs[i].TotalPlays = 1
+	}
+
+	songs[3].TotalPlays = 0
+	songs[4].TotalPlays = 0
+
+	for i, artist := range rest {
+		songs[5+i].Tags.Artist = artist
+	}
+
+	return songs
+}
+
+func undiscoveredIn(songs []SongFile) int {
+	n := 0
+	for i := range songs {
+		if songs[i].undiscovered() {
+			n++
+		}
+	}
+
+	return n
+}
+
+func TestSpreadKeepsDiscoveries(t *testing.T) {
+	defer SetSpreadGaps(SpreadGaps{})
+	defer SetDiscovery(Discovery{})
+	SetSpreadGaps(SpreadGaps{Artist: 1})
+
+	//without a discovery ratio, songs from beyond the batch are pulled in to spread it out
+	l := &SongLibrary{Songs: spreadSongs("b", "c")}
+	l.spreadBatch(5, len(l.Songs))
+
+	if got := l.Songs[1].Tags.Artist; got != "b" {
+		t.Fatalf("expected b to be pulled in to follow a, got %s", got)
+	}
+
+	//but they can't push out the undiscovered songs the ratio keeps in the batch
+	SetDiscovery(Discovery{Ratio: 0.4})
+
+	l = &SongLibrary{Songs: spreadSongs("b", "c")}
+	l.spreadBatch(5, len(l.Songs))
+
+	if got := undiscoveredIn(l.Songs[:5]); got != 2 {
+		t.Fatalf("expected the batch to keep its 2 undiscovered songs, got %d", got)
+	}
+
+	//undiscovered songs from beyond the batch can still take their place
+	l = &SongLibrary{Songs: spreadSongs("b", "c")}
+	l.Songs[6].TotalPlays = 0
+	l.spreadBatch(5, len(l.Songs))
+
+	if got := undiscoveredIn(l.Songs[:5]); got != 2 {
+		t.Fatalf("expected the batch to keep 2 undiscovered songs, got %d", got)
+	}
+
+	if got := l.Songs[1].Tags.Artist; got != "c" {
+		t.Fatalf("expected the undiscovered c to be pulled in to follow a, got %s", got)
+	}
+
+	//and once the batch has more than it needs, the spare ones can go
+	SetDiscovery(Discovery{Ratio: 0.2})
+
+	l = &SongLibrary{Songs: spreadSongs("b", "c")}
+	l.spreadBatch(5, len(l.Songs))
+
+	if got := undiscoveredIn(l.Songs[:5]); got != 1 {
+		t.Fatalf("expected the batch to give up its spare undiscovered song, got %d", got)
+	}
+}
@@ -0,0 +1,279 @@
+package songplayer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+//Tags holds the handful of ID3 fields the player cares about.
+type Tags struct {
+	Title       string `json:"title,omitempty"`
+	Artist      string `json:"artist,omitempty"`
+	AlbumArtist string `json:"album_artist,omitempty"`
+	Album       string `json:"album,omitempty"`
+	Genre       string `json:"genre,omitempty"`
+	Disc        int    `json:"disc,omitempty"`
+	Track       int    `json:"track,omitempty"`
+}
+
+var errNoID3 = errors.New("no id3v2 tag found")
+
+//loadTags reads the ID3v2 tag at the start of the song's file, if it has one.
+func (sF *SongFile) loadTags() error {
+	f, err := os.Open(sF.FileName)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	frames, err := readID3Frames(f)
+	if err != nil {
+		return err
+	}
+
+	sF.Tags = Tags{
+		Title:       frames["TIT2"],
+		Artist:      frames["TPE1"],
+		AlbumArtist: frames["TPE2"],
+		Album:       frames["TALB"],
+		Genre:       frames["TCON"],
+		Disc:        position(frames["TPOS"]),
+		Track:       position(frames["TRCK"]),
+	}
+
+	sF.readReplayGain(frames)
+
+	return nil
+}
+
+//position reads a track or disc number, which is often stored as "3/12".
+func position(s string) int {
+	n, _ := strconv.Atoi(strings.TrimSpace(strings.SplitN(s, "/", 2)[0]))
+	return n
+}
+
+//readID3Frames returns the text frames of an ID3v2.2, 2.3 or 2.4 tag, keyed by their v2.3 frame ID.
+func readID3Frames(r io.Reader) (map[string]string, error) {
+	hdr := make([]byte, 10)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, err
+	}
+
+	if string(hdr[:3]) != "ID3" {
+		return nil, errNoID3
+	}
+
+	major := hdr[3]
+	flags := hdr[5]
+
+	//a corrupt size can claim up to 256MB, so only what's actually there is read in
+	size := syncSafe(hdr[6:10])
+	body, err := ioutil.ReadAll(io.LimitReader(r, int64(size)))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(body) < size {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	//Before v2.4, unsynchronisation covers the whole tag. From v2.4 it's undone frame by frame.
+	if flags&0x80 != 0 && major < 4 {
+		body = undoUnsync(body)
+	}
+
+	//Skip the extended header, whose size is counted differently between versions.
+	if flags&0x40 != 0 && len(body) >= 4 {
+		if major == 4 {
+			body = body[minInt(syncSafe(body[:4]), len(body)):]
+		} else {
+			body = body[minInt(int(binary.BigEndian.Uint32(body[:4]))+4, len(body)):]
+		}
+	}
+
+	idLen, hdrLen := 4, 10
+	if major == 2 {
+		idLen, hdrLen = 3, 6
+	}
+
+	frames := make(map[string]string)
+
+	for len(body) >= hdrLen && body[0] != 0 {
+		id := string(body[:idLen])
+
+		var size int
+		switch major {
+		case 2:
+			size = int(body[3])<<16 | int(body[4])<<8 | int(body[5])
+		case 4:
+			size = syncSafe(body[4:8])
+		default:
+			size = int(binary.BigEndian.Uint32(body[4:8]))
+		}
+
+		if size < 0 || hdrLen+size > len(body) {
+			break
+		}
+
+		data := body[hdrLen : hdrLen+size]
+		format := body[hdrLen-1]
+		body = body[hdrLen+size:]
+
+		if major == 4 {
+			//a data length indicator leads the frame when it's been transformed
+			if format&0x01 != 0 {
+				if len(data) < 4 {
+					continue
+				}
+
+				data = data[4:]
+			}
+
+			if format&0x02 != 0 || flags&0x80 != 0 {
+				data = undoUnsync(data)
+			}
+		}
+
+		if major == 2 {
+			id = v22FrameIDs[id]
+		}
+
+		if id == "TXXX" {
+			//user defined frames are a description followed by their value
+			if parts := strings.SplitN(decodeID3Text(data), "\x00", 2); len(parts) == 2 {
+				frames["TXXX:"+strings.ToUpper(parts[0])] = strings.TrimRight(parts[1], "\x00")
+			}
+		} else if strings.HasPrefix(id, "T") {
+			frames[id] = strings.TrimRight(decodeID3Text(data), "\x00")
+		}
+	}
+
+	return frames, nil
+}
+
+var v22FrameIDs = map[string]string{
+	"TT2": "TIT2",
+	"TP1": "TPE1",
+	"TP2": "TPE2",
+	"TAL": "TALB",
+	"TCO": "TCON",
+	"TRK": "TRCK",
+	"TPA": "TPOS",
+	"TXX": "TXXX",
+}
+
+//undoUnsync drops the zero written after every 0xff byte, which unsynchronisation adds so that
+//nothing in a tag looks like the start of an mpeg frame.
+func undoUnsync(b []byte) []byte {
+	out := make([]byte, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		out = append(out, b[i])
+		if b[i] == 0xff && i+1 < len(b) && b[i+1] == 0 {
+			i++
+		}
+	}
+
+	return out
+}
+
+func syncSafe(b []byte) int {
+	return int(b[0]&0x7f)<<21 | int(b[1]&0x7f)<<14 | int(b[2]&0x7f)<<7 | int(b[3]&0x7f)
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
+
+//decodeID3Text converts a text frame, led by its encoding byte, to UTF-8.
+func decodeID3Text(data []byte) string {
+	if len(data) == 0 {
+		return ""
+	}
+
+	enc, data := data[0], data[1:]
+
+	switch enc {
+	case 1, 2:
+		var order binary.ByteOrder = binary.BigEndian
+		if enc == 1 && len(data) >= 2 {
+			if data[0] == 0xff && data[1] == 0xfe {
+				order = binary.LittleEndian
+			}
+
+			if (data[0] == 0xff && data[1] == 0xfe) || (data[0] == 0xfe && data[1] == 0xff) {
+				data = data[2:]
+			}
+		}
+
+		u := make([]uint16, 0, len(data)/2)
+		for i := 0; i+1 < len(data); i += 2 {
+			u = append(u, order.Uint16(data[i:]))
+		}
+
+		//each string in a frame carries its own byte order mark
+		s := string(utf16.Decode(u))
+		return strings.Replace(s, "\x00\ufeff", "\x00", -1)
+	case 3:
+		return string(data)
+	default:
+		//ISO-8859-1 maps directly onto the first 256 code points
+		var buf bytes.Buffer
+		for _, b := range data {
+			buf.WriteRune(rune(b))
+		}
+
+		return buf.String()
+	}
+}
+
+//Dir is the directory holding the song's file.
+func (sF *SongFile) Dir() string {
+	return path.Dir(sF.FileName)
+}
+
+//Album identifies the record the song belongs to, taken from its tags and falling back to its directory.
+//Albums are told apart by their album artist, so the tracks of a compilation stay together.
+func (sF *SongFile) Album() string {
+	if sF.Tags.Album == "" {
+		return sF.Dir()
+	}
+
+	return sF.AlbumArtist() + "/" + sF.Tags.Album
+}
+
+//AlbumArtist is the artist the song's album is credited to, falling back to the song's own artist.
+func (sF *SongFile) AlbumArtist() string {
+	if sF.Tags.AlbumArtist != "" {
+		return sF.Tags.AlbumArtist
+	}
+
+	return sF.Artist()
+}
+
+//Artist is the song's tagged artist. Untagged songs fall back to the top level folder they sit in
+//under the library dir, which is empty for songs kept directly in the library dir.
+func (sF *SongFile) Artist() string {
+	if sF.Tags.Artist != "" {
+		return sF.Tags.Artist
+	}
+
+	rel := strings.TrimPrefix(sF.FileName, strings.TrimSuffix(libDir, "/")+"/")
+	if i := strings.Index(rel, "/"); i > 0 && rel != sF.FileName {
+		return rel[:i]
+	}
+
+	return ""
+}
@@ -0,0 +1,235 @@
+package songplayer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"testing"
+)
+
+//id3Tag builds an ID3v2 tag of the given version and flags around body.
+func id3Tag(major, flags byte, body []byte) []byte {
+	size := len(body)
+	hdr := []byte{'I', 'D', '3', major, 0, flags,
+		byte(size >> 21 & 0x7f), byte(size >> 14 & 0x7f), byte(size >> 7 & 0x7f), byte(size & 0x7f)}
+
+	return append(hdr, body...)
+}
+
+//id3Frame builds a frame the way the given version lays them out.
+func id3Frame(major byte, id string, format byte, data []byte) []byte {
+	size := len(data)
+
+	var hdr []byte
+	switch major {
+	case 2:
+		hdr = append([]byte(id), byte(size>>16), byte(size>>8), byte(size))
+	case 4:
+		hdr = append([]byte(id), byte(size>>21&0x7f), byte(size>>14&0x7f), byte(size>>7&0x7f), byte(size&0x7f), 0, format)
+	default:
+		hdr = append([]byte(id), 0, 0, 0, 0, 0, format)
+		binary.BigEndian.PutUint32(hdr[4:], uint32(size))
+	}
+
+	return append(hdr, data...)
+}
+
+func latin1(s string) []byte   { return append([]byte{0}, s...) }
+func utf8Text(s string) []byte { return append([]byte{3}, s...) }
+
+//utf16Text encodes s as UTF-16 with a byte order mark, the way encoding 1 is written.
+func utf16Text(s string, order binary.ByteOrder) []byte {
+	b := []byte{1}
+	if order == binary.LittleEndian {
+		b = append(b, 0xff, 0xfe)
+	} else {
+		b = append(b, 0xfe, 0xff)
+	}
+
+	for _, r := range s {
+		u := make([]byte, 2)
+		order.PutUint16(u, uint16(r))
+		b = append(b, u...)
+	}
+
+	return b
+}
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func expectFrames(t *testing.T, name string, tag []byte, want map[string]string) {
+	t.Helper()
+
+	frames, err := readID3Frames(bytes.NewReader(tag))
+	if err != nil {
+		t.Fatalf("%s: unexpected error: %v", name, err)
+	}
+
+	for id, v := range want {
+		if frames[id] != v {
+			t.Errorf("%s: expected %s to be %q, got %q", name, id, v, frames[id])
+		}
+	}
+}
+
+func TestReadID3v22(t *testing.T) {
+	tag := id3Tag(2, 0, concat(
+		id3Frame(2, "TT2", 0, latin1("So What")),
+		id3Frame(2, "TP1", 0, latin1("Miles Davis")),
+		id3Frame(2, "TAL", 0, latin1("Kind of Blue")),
+		id3Frame(2, "TCO", 0, latin1("Jazz")),
+		id3Frame(2, "TRK", 0, latin1("1/5")),
+		id3Frame(2, "TXX", 0, latin1("replaygain_track_gain\x00-6.5 dB")),
+		make([]byte, 16), //padding
+	))
+
+	expectFrames(t, "v2.2", tag, map[string]string{
+		"TIT2":                       "So What",
+		"TPE1":                       "Miles Davis",
+		"TALB":                       "Kind of Blue",
+		"TCON":                       "Jazz",
+		"TRCK":                       "1/5",
+		"TXXX:REPLAYGAIN_TRACK_GAIN": "-6.5 dB",
+	})
+}
+
+func TestReadID3v23(t *testing.T) {
+	frames := concat(
+		id3Frame(3, "TIT2", 0, latin1("Caf\xe9")),
+		id3Frame(3, "TPE1", 0, utf16Text("Björk", binary.LittleEndian)),
+		id3Frame(3, "TALB", 0, utf16Text("Homogenic", binary.BigEndian)),
+		id3Frame(3, "TXXX", 0, concat(utf16Text("REPLAYGAIN_TRACK_PEAK", binary.LittleEndian), []byte{0, 0}, utf16Text("0.98", binary.LittleEndian)[1:])),
+		id3Frame(3, "APIC", 0, []byte{0, 'i', 'm', 'g'}),
+	)
+
+	expectFrames(t, "v2.3", id3Tag(3, 0, frames), map[string]string{
+		"TIT2":                       "Café",
+		"TPE1":                       "Björk",
+		"TALB":                       "Homogenic",
+		"TXXX:REPLAYGAIN_TRACK_PEAK": "0.98",
+	})
+
+	//the extended header's size doesn't count its own size field before v2.4
+	ext := []byte{0, 0, 0, 6, 0, 0, 0, 0, 0, 0}
+	expectFrames(t, "v2.3 extended header", id3Tag(3, 0x40, concat(ext, frames)), map[string]string{
+		"TIT2": "Café",
+	})
+
+	//unsynchronisation puts a zero after every 0xff in the tag
+	synced := id3Frame(3, "TPE1", 0, latin1("\xff\xfe"))
+	var unsynced []byte
+	for _, b := range synced {
+		unsynced = append(unsynced, b)
+		if b == 0xff {
+			unsynced = append(unsynced, 0)
+		}
+	}
+
+	expectFrames(t, "v2.3 unsynchronisation", id3Tag(3, 0x80, unsynced), map[string]string{
+		"TPE1": "ÿþ",
+	})
+}
+
+func TestReadID3v24(t *testing.T) {
+	//a frame over 127 bytes shows whether its size is read as synchsafe
+	long := string(bytes.Repeat([]byte("a"), 200))
+
+	frames := concat(
+		id3Frame(4, "TIT2", 0, utf8Text("Jóga")),
+		id3Frame(4, "TCON", 0, utf8Text(long)),
+		id3Frame(4, "TPE2", 0, []byte{2, 0, 'B', 0, 'j'}),
+		id3Frame(4, "TXXX", 0, utf8Text("replaygain_album_gain\x00+1.2 dB")),
+	)
+
+	expectFrames(t, "v2.4", id3Tag(4, 0, frames), map[string]string{
+		"TIT2":                       "Jóga",
+		"TCON":                       long,
+		"TPE2":                       "Bj",
+		"TXXX:REPLAYGAIN_ALBUM_GAIN": "+1.2 dB",
+	})
+
+	//the extended header's synchsafe size includes its own size field from v2.4
+	ext := []byte{0, 0, 0, 6, 1, 0}
+	expectFrames(t, "v2.4 extended header", id3Tag(4, 0x40, concat(ext, frames)), map[string]string{
+		"TIT2": "Jóga",
+	})
+
+	//unsynchronisation is flagged per frame, along with the data length indicator
+	data := concat([]byte{0, 0, 0, 3}, []byte{0, 0xff, 0, 'x'})
+	expectFrames(t, "v2.4 unsynchronisation", id3Tag(4, 0, id3Frame(4, "TPE1", 0x03, data)), map[string]string{
+		"TPE1": "ÿx",
+	})
+}
+
+func TestReadID3Corrupt(t *testing.T) {
+	if _, err := readID3Frames(bytes.NewReader([]byte("not a tag at all"))); err != errNoID3 {
+		t.Errorf("expected errNoID3 for a file without a tag, got %v", err)
+	}
+
+	truncated := [][]byte{
+		nil,
+		[]byte("ID3"),
+		id3Tag(3, 0, make([]byte, 100))[:50],
+	}
+
+	for _, tag := range truncated {
+		if _, err := readID3Frames(bytes.NewReader(tag)); err == nil {
+			t.Errorf("expected an error reading the truncated tag %q", tag)
+		}
+	}
+
+	//frames running past the end of the tag stop the parse, keeping what came before
+	frame := id3Frame(3, "TIT2", 0, latin1("So What"))
+	overrun := id3Frame(3, "TPE1", 0, latin1("Miles Davis"))
+	overrun[7] = 200
+
+	expectFrames(t, "overrun", id3Tag(3, 0, concat(frame, overrun)), map[string]string{"TIT2": "So What"})
+
+	corrupt := [][]byte{
+		id3Tag(3, 0x40, []byte{0xff, 0xff, 0xff, 0xff, 1, 2}),
+		id3Tag(4, 0x40, []byte{0x7f, 0x7f, 0x7f, 0x7f}),
+		id3Tag(3, 0x40, []byte{1, 2}),
+		id3Tag(4, 0, id3Frame(4, "TIT2", 0x01, []byte{0, 0})),
+		id3Tag(3, 0, id3Frame(3, "TIT2", 0, nil)),
+		id3Tag(3, 0, id3Frame(3, "TIT2", 0, []byte{1, 0xff})),
+		id3Tag(3, 0, id3Frame(3, "TXXX", 0, []byte{1})),
+	}
+
+	//fill out the corrupt tags with random ones, none of which should panic
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		body := make([]byte, rng.Intn(64))
+		rng.Read(body)
+		corrupt = append(corrupt, id3Tag(byte(2+rng.Intn(3)), byte(rng.Intn(256)), body))
+	}
+
+	//and with valid tags that have had a few bytes mangled
+	valid := [][]byte{
+		id3Tag(2, 0, concat(id3Frame(2, "TT2", 0, latin1("So What")), id3Frame(2, "TXX", 0, latin1("a\x00b")))),
+		id3Tag(3, 0x80, concat(frame, id3Frame(3, "TXXX", 0, utf16Text("a\x00b", binary.BigEndian)))),
+		id3Tag(4, 0, concat(id3Frame(4, "TIT2", 0x03, []byte{0, 0, 0, 2, 3, 'a'}), id3Frame(4, "TPE1", 0, utf8Text("b")))),
+	}
+
+	for i := 0; i < 500; i++ {
+		tag := append([]byte(nil), valid[i%len(valid)]...)
+		for j := 0; j < 3; j++ {
+			tag[3+rng.Intn(len(tag)-3)] = byte(rng.Intn(256))
+		}
+
+		corrupt = append(corrupt, tag)
+	}
+
+	for _, tag := range corrupt {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Errorf("reading %x panicked: %v", tag, r)
+				}
+			}()
+
+			_, _ = readID3Frames(bytes.NewReader(tag))
+		}()
+	}
+}
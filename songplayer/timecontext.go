@@ -0,0 +1,86 @@
+package songplayer
+
+import (
+	"math"
+	"time"
+)
+
+//TimeContext tallies when a song was played and skipped, by hour of the day and day of the week.
+type TimeContext struct {
+	HourPlays []uint32 `json:"hour_plays,omitempty"`
+	HourSkips []uint32 `json:"hour_skips,omitempty"`
+	DayPlays  []uint32 `json:"weekday_plays,omitempty"`
+	DaySkips  []uint32 `json:"weekday_skips,omitempty"`
+}
+
+//record tallies a play or skip at t.
+func (tc *TimeContext) record(t time.Time, skipped bool) {
+	hours, days := &tc.HourPlays, &tc.DayPlays
+	if skipped {
+		hours, days = &tc.HourSkips, &tc.DaySkips
+	}
+
+	if *hours == nil {
+		*hours = make([]uint32, 24)
+	}
+
+	if *days == nil {
+		*days = make([]uint32, 7)
+	}
+
+	(*hours)[t.Hour()]++
+	(*days)[t.Weekday()]++
+}
+
+//hourSimilarity is 1 for the same hour, falling off quickly to 0 twelve hours away.
+func hourSimilarity(a, b int) float64 {
+	return math.Pow((1+math.Cos(2*math.Pi*float64(a-b)/24))/2, 4)
+}
+
+//daySimilarity treats weekdays as like one another, and likewise weekends.
+func daySimilarity(a, b time.Weekday) float64 {
+	weekend := func(d time.Weekday) bool { return d == time.Saturday || d == time.Sunday }
+
+	switch {
+	case a == b:
+		return 1
+	case weekend(a) == weekend(b):
+		return 0.5
+	default:
+		return 0.1
+	}
+}
+
+//affinity is how much the song is liked around t, from -1 when it's always skipped at times like
+//it to 1 when it's always played through. Songs with no history around t have no affinity.
+func (tc *TimeContext) affinity(t time.Time) float64 {
+	//the +1 keeps a single play from counting as much as a long history
+	ratio := func(plays, skips []uint32, sim func(i int) float64) float64 {
+		var net, total float64
+		for i := range plays {
+			net += sim(i) * float64(plays[i])
+			total += sim(i) * float64(plays[i])
+		}
+
+		for i := range skips {
+			net -= sim(i) * float64(skips[i])
+			total += sim(i) * float64(skips[i])
+		}
+
+		return net / (total + 1)
+	}
+
+	hour := ratio(tc.HourPlays, tc.HourSkips, func(h int) float64 { return hourSimilarity(h, t.Hour()) })
+	day := ratio(tc.DayPlays, tc.DaySkips, func(d int) float64 { return daySimilarity(time.Weekday(d), t.Weekday()) })
+
+	return (hour + day) / 2
+}
+
+//timeContextScore is how far the time of day moves the song up or down the order at t.
+func (pI *PlayInfo) timeContextScore(t time.Time) float64 {
+	if weights.TimeContext == 0 {
+		return 0
+	}
+
+	return weights.TimeContext * pI.TimeContext.affinity(t)
+}
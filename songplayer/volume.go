@@ -0,0 +1,70 @@
+package songplayer
+
+import (
+	"fmt"
+
+	"github.com/faiface/beep/effects"
+)
+
+//volumeRange is how many halvings of gain the volume covers, from 100% down to just above 0%.
+const volumeRange = 6
+
+//setVolume applies a volume or mute command, which carry on across songs and restarts.
+func (lib *SongLibrary) setVolume(cmd Command) error {
+	lib.mu.Lock()
+
+	switch cmd.Signal {
+	case SignalVolume:
+		lib.Volume += int(cmd.Value)
+	case SignalSetVolume:
+		if cmd.Value < 0 || cmd.Value > 100 {
+			lib.mu.Unlock()
+			return fmt.Errorf("volume must be between 0 and 100. got: %d", cmd.Value)
+		}
+		lib.Volume = int(cmd.Value)
+	case SignalMute:
+		lib.Muted = cmd.Value != 0
+	}
+
+	if lib.Volume < 0 {
+		lib.Volume = 0
+	} else if lib.Volume > 100 {
+		lib.Volume = 100
+	}
+
+	volume, muted := lib.Volume, lib.Muted
+	lib.mu.Unlock()
+
+	player.applyVolume(volume, muted)
+	return nil
+}
+
+//applyVolume sets the gain of everything the deck plays, from a volume between 0 and 100. Every
+//100/volumeRange below 100 halves it, which is about 6dB.
+func (d *deck) applyVolume(volume int, muted bool) {
+	//the volume control is set up when the first song plays, maybe while a command comes in
+	output.Lock()
+	defer output.Unlock()
+
+	if d.volume == nil {
+		return
+	}
+
+	d.volume.Silent = muted || volume == 0
+	d.volume.Volume = float64(volume-100) / 100 * volumeRange
+}
+
+//newVolume wraps the deck's output in a volume control set to the library's saved volume.
+func (d *deck) newVolume() {
+	v := &effects.Volume{Streamer: d.ctrl, Base: 2}
+
+	output.Lock()
+	d.volume = v
+	output.Unlock()
+
+	lib.mu.RLock()
+	volume, muted := lib.Volume, lib.Muted
+	lib.mu.RUnlock()
+
+	d.applyVolume(volume, muted)
+}
@@ -0,0 +1,116 @@
+package songplayer
+
+import (
+	"math"
+	"testing"
+
+	"github.com/faiface/beep/effects"
+)
+
+//useVolume swaps in a volume control for the deck, returning a func putting the old one back.
+func useVolume(t *testing.T) (*effects.Volume, func()) {
+	useNullOutput(t)
+
+	v := &effects.Volume{Base: 2}
+
+	output.Lock()
+	prev := player.volume
+	player.volume = v
+	output.Unlock()
+
+	return v, func() {
+		output.Lock()
+		player.volume = prev
+		output.Unlock()
+	}
+}
+
+func TestVolumeGain(t *testing.T) {
+	v, restore := useVolume(t)
+	defer restore()
+
+	tests := []struct {
+		volume int
+		muted  bool
+		db     float64
+		silent bool
+	}{
+		{100, false, 0, false},
+		{50, false, -18.06, false},
+		{25, false, -27.09, false},
+		{1, false, -35.76, false},
+		{0, false, -36.12, true},
+		{100, true, 0, true},
+	}
+
+	for _, tt := range tests {
+		player.applyVolume(tt.volume, tt.muted)
+
+		if db := 20 * math.Log10(math.Pow(v.Base, v.Volume)); math.Abs(db-tt.db) > 0.01 || v.Silent != tt.silent {
+			t.Errorf("volume %d, muted %v: expected %.2fdB and silent %v, got %.2fdB and %v", tt.volume, tt.muted, tt.db, tt.silent, db, v.Silent)
+		}
+	}
+}
+
+func TestSetVolume(t *testing.T) {
+	defer useLibrary(testSongs(1))()
+
+	v, restore := useVolume(t)
+	defer restore()
+
+	tests := []struct {
+		cmd    Command
+		volume int
+		muted  bool
+		err    bool
+	}{
+		{Command{Signal: SignalVolume, Value: -30}, 70, false, false},
+		{Command{Signal: SignalVolume, Value: 50}, 100, false, false},
+		{Command{Signal: SignalVolume, Value: -250}, 0, false, false},
+		{Command{Signal: SignalSetVolume, Value: 40}, 40, false, false},
+		{Command{Signal: SignalSetVolume, Value: 140}, 40, false, true},
+		{Command{Signal: SignalSetVolume, Value: -1}, 40, false, true},
+		{Command{Signal: SignalMute, Value: 1}, 40, true, false},
+		{Command{Signal: SignalVolume, Value: 10}, 50, true, false},
+		{Command{Signal: SignalMute}, 50, false, false},
+	}
+
+	for _, tt := range tests {
+		if err := lib.setVolume(tt.cmd); (err != nil) != tt.err {
+			t.Fatalf("%+v: expected an error %v, got %v", tt.cmd, tt.err, err)
+		}
+
+		if lib.Volume != tt.volume || lib.Muted != tt.muted {
+			t.Fatalf("%+v: expected volume %d and muted %v, got %d and %v", tt.cmd, tt.volume, tt.muted, lib.Volume, lib.Muted)
+		}
+
+		//the deck follows along
+		if want := float64(tt.volume-100) / 100 * volumeRange; v.Volume != want || v.Silent != (tt.muted || tt.volume == 0) {
+			t.Fatalf("%+v: expected the deck at %v and silent %v, got %v and %v", tt.cmd, want, tt.muted, v.Volume, v.Silent)
+		}
+	}
+}
+
+func TestVolumeWhileStarting(t *testing.T) {
+	defer useLibrary(testSongs(1))()
+	useNullOutput(t)
+
+	//a volume command can come in while the first song sets up the volume control
+	var d deck
+	started := make(chan struct{})
+	go func() {
+		d.newVolume()
+		close(started)
+	}()
+
+	d.applyVolume(50, false)
+	<-started
+	d.applyVolume(50, false)
+
+	output.Lock()
+	defer output.Unlock()
+
+	if d.volume.Volume != -volumeRange/2 {
+		t.Fatalf("expected the volume to be set to half, got %v", d.volume.Volume)
+	}
+}
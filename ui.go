@@ -1,41 +1,69 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"path"
+	"strings"
 	"sync/atomic"
 	"time"
 
 	"github.com/gdamore/tcell"
 	"github.com/rivo/tview"
+	"golang.org/x/sys/unix"
 
+	"github.com/dwood15/mediaplayer/sockets"
 	"github.com/dwood15/mediaplayer/songplayer"
 )
 
 func (u *UIController) gridView() *tview.Grid {
-	newPrimitive := func(text string) tview.Primitive {
-		return tview.NewTextView().
-			SetTextAlign(tview.AlignCenter).
-			SetText(text)
-	}
-
 	view := tview.NewTextView().
 		SetTextAlign(tview.AlignCenter).
 		SetDrawFunc(u.drawTime)
 
+	u.queueList = tview.NewList().ShowSecondaryText(false)
+	u.queueList.SetTitle("Up Next")
+
 	return tview.NewGrid().
 		SetSize(1, 1, 10, 10).
 		SetMinSize(10, 10).
 		SetBorders(true).
-		AddItem(newPrimitive("Header"), 0, 0, 1, 1, 0, 0, false).
+		AddItem(u.queueList, 0, 0, 1, 1, 0, 0, true).
 		AddItem(view, 1, 1, 1, 1, 0, 0, false)
 }
 
+//searchView is the song picker: a search box over the songs it found.
+func (u *UIController) searchView() *tview.Flex {
+	u.searchInput = tview.NewInputField().
+		SetLabel("Search: ").
+		SetDoneFunc(func(key tcell.Key) {
+			if key == tcell.KeyEnter {
+				u.search(u.searchInput.GetText())
+			}
+		})
+
+	u.foundList = tview.NewList().ShowSecondaryText(false)
+	u.foundList.SetBorder(true).SetTitle("Enter plays next, a adds to the queue, / searches again, Esc goes back")
+
+	return tview.NewFlex().
+		SetDirection(tview.FlexRow).
+		AddItem(u.searchInput, 1, 0, true).
+		AddItem(u.foundList, 0, 1, false)
+}
+
 var app = tview.NewApplication()
 
 type UIController struct {
 	SongState    *atomic.Value
-	InputChan    chan int64
+	InputChan    chan songplayer.Command
 	currentState songplayer.PlayingSong
+	queueList    *tview.List
+	shownQueue   []string
+	pages        *tview.Pages
+	searchInput  *tview.InputField
+	foundList    *tview.List
+	found        []string
+	searching    bool
 }
 
 func (u *UIController) launchUI() {
@@ -46,30 +74,248 @@ func (u *UIController) launchUI() {
 			select {
 			case <-tckr.C:
 				u.currentState = u.SongState.Load().(songplayer.PlayingSong)
+				u.refreshQueue()
 				app.Draw()
 			}
 		}
 	}()
 
 	app.SetInputCapture(func(e *tcell.EventKey) *tcell.EventKey {
+		if u.searching {
+			return u.searchKey(e)
+		}
+
 		switch e.Key() {
 		case tcell.KeyTAB:
-			u.InputChan <- songplayer.SignalSkip
+			u.InputChan <- songplayer.Command{Signal: songplayer.SignalSkip}
 		case tcell.KeyEnter:
-			u.InputChan <- songplayer.SignalPause
+			u.InputChan <- songplayer.Command{Signal: songplayer.SignalPause}
 		case tcell.KeyEsc:
-			u.InputChan <- songplayer.SignalExit
+			u.InputChan <- songplayer.Command{Signal: songplayer.SignalExit}
 			app.Stop()
+		case tcell.KeyDelete:
+			u.dequeueSelected()
+		case tcell.KeyLeft:
+			u.InputChan <- songplayer.Command{Signal: songplayer.SignalSeek, Value: -5000}
+		case tcell.KeyRight:
+			u.InputChan <- songplayer.Command{Signal: songplayer.SignalSeek, Value: 5000}
+		case tcell.KeyHome:
+			u.InputChan <- songplayer.Command{Signal: songplayer.SignalRestart}
+		case tcell.KeyRune:
+			if !u.handleRune(e.Rune()) {
+				return e
+			}
+		default:
+			return e
 		}
 
-		return e
+		//the queue list has focus, and would otherwise act on the keys as well
+		return nil
 	})
 
-	if err := app.SetRoot(u.gridView(), true).Run(); err != nil {
+	u.pages = tview.NewPages().
+		AddPage("player", u.gridView(), true, true).
+		AddPage("search", u.searchView(), true, false)
+
+	if err := app.SetRoot(u.pages, true).Run(); err != nil {
 		panic(err)
 	}
 }
 
+//handleRune sends the command bound to a character key, returning false when there isn't one.
+// 0-5 rates the playing song (0 clears it), f toggles favourite and b toggles never play.
+// n queues the playing song to play again next, a adds it to the end of the queue, x removes the
+// selected song from the queue and [ and ] move it up and down. < and > seek back and forward 30 seconds.
+// + and - turn the volume up and down, and m mutes it. p goes back to the last song and r restarts this one.
+// / opens the search, to queue any song in the library.
+func (u *UIController) handleRune(r rune) bool {
+	switch {
+	case r >= '0' && r <= '5':
+		u.InputChan <- songplayer.Command{Signal: songplayer.SignalRate, Value: int64(r - '0')}
+	case r == 'f':
+		u.InputChan <- songplayer.Command{Signal: songplayer.SignalFavourite, Value: toggled(u.currentState.Favourite)}
+	case r == 'b':
+		u.InputChan <- songplayer.Command{Signal: songplayer.SignalBan, Value: toggled(u.currentState.Banned)}
+	case r == 'n':
+		u.InputChan <- songplayer.Command{Signal: songplayer.SignalPlayNext}
+	case r == 'a':
+		u.InputChan <- songplayer.Command{Signal: songplayer.SignalEnqueue}
+	case r == 'x':
+		u.dequeueSelected()
+	case r == '[':
+		u.moveSelected(-1)
+	case r == ']':
+		u.moveSelected(1)
+	case r == '<':
+		u.InputChan <- songplayer.Command{Signal: songplayer.SignalSeek, Value: -30000}
+	case r == '>':
+		u.InputChan <- songplayer.Command{Signal: songplayer.SignalSeek, Value: 30000}
+	case r == '+' || r == '=':
+		u.InputChan <- songplayer.Command{Signal: songplayer.SignalVolume, Value: 5}
+	case r == '-':
+		u.InputChan <- songplayer.Command{Signal: songplayer.SignalVolume, Value: -5}
+	case r == 'm':
+		u.InputChan <- songplayer.Command{Signal: songplayer.SignalMute, Value: toggled(u.currentState.Muted)}
+	case r == 'p':
+		u.InputChan <- songplayer.Command{Signal: songplayer.SignalPrevious}
+	case r == 'r':
+		u.InputChan <- songplayer.Command{Signal: songplayer.SignalRestart}
+	case r == '/':
+		u.openSearch()
+	default:
+		return false
+	}
+
+	return true
+}
+
+//openSearch shows the song picker over the player.
+func (u *UIController) openSearch() {
+	u.searching = true
+	u.pages.SwitchToPage("search")
+	app.SetFocus(u.searchInput)
+}
+
+//closeSearch goes back to the player.
+func (u *UIController) closeSearch() {
+	u.searching = false
+	u.pages.SwitchToPage("player")
+	app.SetFocus(u.queueList)
+}
+
+//searchKey handles the keys pressed while the song picker is open. The search box gets every key
+//but Esc, so the player's own bindings are left alone while typing.
+func (u *UIController) searchKey(e *tcell.EventKey) *tcell.EventKey {
+	switch {
+	case e.Key() == tcell.KeyEsc:
+		u.closeSearch()
+	case app.GetFocus() == u.searchInput:
+		return e
+	case e.Key() == tcell.KeyEnter:
+		u.queueFound(songplayer.SignalPlayNext)
+	case e.Key() == tcell.KeyRune && e.Rune() == 'a':
+		u.queueFound(songplayer.SignalEnqueue)
+	case e.Key() == tcell.KeyRune && e.Rune() == '/':
+		app.SetFocus(u.searchInput)
+	default:
+		return e
+	}
+
+	return nil
+}
+
+//search asks the player for the songs matching text, and lists them once it answers.
+func (u *UIController) search(text string) {
+	u.foundList.Clear()
+	u.foundList.AddItem("searching...", "", 0, nil)
+
+	go func() {
+		var found []string
+
+		reply, err := sockets.Request(&unix.SockaddrUnix{Name: sockName}, songplayer.Command{Signal: songplayer.SignalSearch, Target: text}, "songs")
+		if err == nil {
+			err = json.Unmarshal(reply, &found)
+		}
+
+		app.QueueUpdateDraw(func() {
+			u.found = found
+			u.foundList.Clear()
+
+			switch {
+			case err != nil:
+				u.foundList.AddItem("unable to search: "+err.Error(), "", 0, nil)
+			case len(found) == 0:
+				u.foundList.AddItem("no songs found", "", 0, nil)
+			}
+
+			for _, name := range found {
+				u.foundList.AddItem(path.Base(name)+"  "+path.Dir(name), "", 0, nil)
+			}
+
+			if len(found) > 0 {
+				app.SetFocus(u.foundList)
+			}
+		})
+	}()
+}
+
+//queueFound queues the selected search result, by its full file name.
+func (u *UIController) queueFound(signal int64) {
+	at := u.foundList.GetCurrentItem()
+	if at >= len(u.found) {
+		return
+	}
+
+	u.InputChan <- songplayer.Command{Signal: signal, Target: u.found[at]}
+}
+
+//refreshQueue rebuilds the queue list when the player's queue has changed.
+func (u *UIController) refreshQueue() {
+	queue := u.currentState.Queue
+	if equalNames(queue, u.shownQueue) {
+		return
+	}
+
+	u.shownQueue = queue
+	app.QueueUpdate(func() {
+		selected := u.queueList.GetCurrentItem()
+		u.queueList.Clear()
+
+		for _, name := range queue {
+			u.queueList.AddItem(path.Base(name), "", 0, nil)
+		}
+
+		if selected < len(queue) {
+			u.queueList.SetCurrentItem(selected)
+		}
+	})
+}
+
+//dequeueSelected removes the selected song from the queue.
+func (u *UIController) dequeueSelected() {
+	if u.queueList.GetItemCount() == 0 {
+		return
+	}
+
+	u.InputChan <- songplayer.Command{Signal: songplayer.SignalDequeue, Value: int64(u.queueList.GetCurrentItem())}
+}
+
+//moveSelected moves the selected song by offset places in the queue, keeping it selected.
+func (u *UIController) moveSelected(offset int) {
+	at := u.queueList.GetCurrentItem()
+	to := at + offset
+
+	if at >= len(u.shownQueue) || to < 0 || to >= len(u.shownQueue) {
+		return
+	}
+
+	u.InputChan <- songplayer.Command{Signal: songplayer.SignalMoveQueued, Value: int64(to), Target: u.shownQueue[at]}
+	u.queueList.SetCurrentItem(to)
+}
+
+func equalNames(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+//toggled returns the command value that flips a flag
+func toggled(b bool) int64 {
+	if b {
+		return 0
+	}
+
+	return 1
+}
+
 func fmtDuration(d time.Duration) string {
 	d = d.Truncate(time.Second)
 	m := d / time.Minute
@@ -83,5 +329,29 @@ func (u *UIController) drawTime(screen tcell.Screen, x int, y int, width int, he
 	ht := height / 2
 	tview.Print(screen, timeStr, x, ht, width, tview.AlignCenter, tcell.ColorTomato)
 	tview.Print(screen, u.currentState.CurrentSong, x, ht+1, width, tview.AlignCenter, tcell.ColorTomato)
+	tview.Print(screen, fmtPreferences(u.currentState), x, ht+2, width, tview.AlignCenter, tcell.ColorTomato)
+	tview.Print(screen, fmtVolume(u.currentState), x, ht+3, width, tview.AlignCenter, tcell.ColorTomato)
 	return x, y, width, height
 }
+
+func fmtPreferences(ps songplayer.PlayingSong) string {
+	prefs := strings.Repeat("*", int(ps.Rating)) + strings.Repeat("-", 5-int(ps.Rating))
+
+	if ps.Favourite {
+		prefs += " [favourite]"
+	}
+
+	if ps.Banned {
+		prefs += " [never play]"
+	}
+
+	return prefs
+}
+
+func fmtVolume(ps songplayer.PlayingSong) string {
+	if ps.Muted {
+		return "muted"
+	}
+
+	return fmt.Sprintf("vol %d%%", ps.Volume)
+}